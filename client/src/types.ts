@@ -15,6 +15,8 @@ export interface School {
   level?: string;
   st_grade?: string;
   end_grade?: string;
+  st_grade_ord?: number;
+  end_grade_ord?: number;
   enrollment?: number;
   ft_teacher?: number;
   type?: number;
@@ -41,6 +43,8 @@ export interface SchoolsResponse {
   total: number;
   page: number;
   pageSize: number;
+  truncated?: boolean;
+  next_cursor?: string;
 }
 
 // Define types for map elements
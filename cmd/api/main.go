@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -36,8 +37,19 @@ func main() {
 	// Create repository
 	schoolRepo := repository.NewSchoolRepository(database.DB)
 
+	// Jobs left running by a previous process will never finish
+	if n, err := schoolRepo.FailInterruptedImportJobs(); err != nil {
+		log.Printf("Warning: %v", err)
+	} else if n > 0 {
+		log.Printf("Marked %d interrupted import jobs as failed", n)
+	}
+
 	// Create handlers
 	schoolHandler := handlers.NewSchoolHandler(schoolRepo)
+	schoolHandler.ImportDir = getEnv("IMPORT_DIR", "")
+	if maxBytes, err := strconv.ParseInt(getEnv("IMPORT_MAX_BYTES", ""), 10, 64); err == nil && maxBytes > 0 {
+		schoolHandler.MaxImportBytes = maxBytes
+	}
 
 	// Create router
 	r := mux.NewRouter()
@@ -49,11 +61,24 @@ func main() {
 	schools := api.PathPrefix("/schools").Subrouter()
 	schools.HandleFunc("", schoolHandler.GetSchools).Methods("GET")
 	schools.HandleFunc("", schoolHandler.CreateSchool).Methods("POST")
+	schools.HandleFunc("/nearby", schoolHandler.GetNearbySchools).Methods("GET")
+	schools.HandleFunc("/clusters", schoolHandler.GetSchoolClusters).Methods("GET")
+	schools.HandleFunc("/search", schoolHandler.SearchSchools).Methods("GET")
+	schools.HandleFunc("/autocomplete", schoolHandler.AutocompleteSchools).Methods("GET")
+	schools.HandleFunc("/facets", schoolHandler.GetSchoolFacets).Methods("GET")
+	schools.HandleFunc("/export", schoolHandler.ExportSchools).Methods("GET")
 	schools.HandleFunc("/{id:[0-9]+}", schoolHandler.GetSchool).Methods("GET")
 	schools.HandleFunc("/{id:[0-9]+}", schoolHandler.UpdateSchool).Methods("PUT")
 	schools.HandleFunc("/{id:[0-9]+}", schoolHandler.DeleteSchool).Methods("DELETE")
 	schools.HandleFunc("/import", schoolHandler.ImportGeoJSON).Methods("POST")
 
+	// Import job routes
+	api.HandleFunc("/imports/{id:[0-9]+}", schoolHandler.GetImportJob).Methods("GET")
+
+	// Vector tile routes
+	tiles := api.PathPrefix("/tiles").Subrouter()
+	tiles.HandleFunc("/schools/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.mvt", schoolHandler.GetSchoolTile).Methods("GET")
+
 	// Add CORS middleware
 	corsMiddleware := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
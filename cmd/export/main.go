@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/pistolricks/api-clients/internal/database"
+	"github.com/pistolricks/api-clients/internal/repository"
+)
+
+func main() {
+	// Parse command line flags
+	format := flag.String("format", repository.ExportGeoPackage, "output format: gpkg, shp, fgb, pmtiles, kml, csv, ndjson or geojson")
+	outPath := flag.String("out", "", "file to write (default: schools.gpkg, schools.zip for shp, and so on)")
+	states := flag.String("state", "", "only export schools in these comma separated states")
+	levels := flag.String("level", "", "only export schools with these comma separated levels")
+	group := flag.String("group", repository.KMLGroupState, "KML folder per state or level")
+	minZoom := flag.Int("minzoom", 0, "PMTiles minimum zoom level")
+	maxZoom := flag.Int("maxzoom", 14, "PMTiles maximum zoom level")
+	attributes := flag.String("fields", strings.Join(repository.DefaultTileAttributes, ","), "PMTiles comma separated tile attributes")
+	force := flag.Bool("force", false, "replace the output file if it exists")
+	flag.Parse()
+
+	if *outPath == "" {
+		*outPath = "schools." + repository.ExportExtension(*format)
+	}
+
+	filter := repository.SchoolFilter{
+		State: splitList(*states),
+		Level: splitList(*levels),
+	}
+
+	// Load environment variables
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using environment variables")
+	}
+
+	// Initialize database
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.CloseDB()
+
+	// Create repository
+	schoolRepo := repository.NewSchoolRepository(database.DB)
+
+	// The writers refuse to overwrite files, so only replace an existing
+	// output when asked to
+	if *force {
+		if err := os.Remove(*outPath); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Export failed: %v", err)
+		}
+	}
+
+	if *format == repository.ExportPMTiles {
+		log.Printf("Exporting school tiles to %s (zoom %d-%d)", *outPath, *minZoom, *maxZoom)
+		opts := repository.PMTilesOptions{MinZoom: *minZoom, MaxZoom: *maxZoom, Attributes: splitList(*attributes)}
+		count, err := schoolRepo.ExportPMTiles(*outPath, filter, opts)
+		if err != nil {
+			log.Fatalf("Export failed after %d tiles: %v", count, err)
+		}
+		log.Printf("Exported %d tiles", count)
+		return
+	}
+
+	log.Printf("Exporting schools to %s (%s)", *outPath, *format)
+	count, err := export(schoolRepo, *format, *outPath, *group, filter)
+	if err != nil {
+		log.Fatalf("Export failed after %d schools: %v", count, err)
+	}
+	log.Printf("Exported %d schools", count)
+}
+
+// export writes the schools matching filter to a new file at path. File
+// formats are written by their own writers; the others are streamed to the
+// file; KML is put in folders by group.
+func export(repo *repository.SchoolRepository, format, path, group string, filter repository.SchoolFilter) (int, error) {
+	if repository.IsFileExportFormat(format) {
+		writer, err := repository.NewSchoolFileWriter(format, path)
+		if err != nil {
+			return 0, err
+		}
+		return repo.ExportSchools(filter, repository.SchoolSort{}, writer)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	buffered := bufio.NewWriter(file)
+	var writer repository.SchoolWriter
+	if format == repository.ExportKML {
+		writer, err = repository.NewKMLWriter(buffered, group)
+	} else {
+		writer, err = repository.NewSchoolWriter(format, buffered)
+	}
+	if err != nil {
+		return 0, err
+	}
+	count, err := repo.ExportSchools(filter, repository.SchoolSort{}, writer)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		// Leave no truncated export behind
+		os.Remove(path)
+	}
+	return count, err
+}
+
+// splitList splits a comma separated flag value, dropping empty values
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/pistolricks/api-clients/internal/database"
+	"github.com/pistolricks/api-clients/internal/models"
+	"github.com/pistolricks/api-clients/internal/repository"
+)
+
+func main() {
+	// Parse command line flags
+	filePath := flag.String("file", "./us-public-schools.geojson", "GeoJSON FeatureCollection or CSV file to import")
+	format := flag.String("format", "", "input format, geojson or csv (default: from the file extension)")
+	mappingValue := flag.String("mapping", "default", "CSV column mapping: default, ccd, a JSON object or a JSON file")
+	modeName := flag.String("mode", "insert-only", "how to write schools: insert-only, upsert or replace")
+	dryRun := flag.Bool("dry-run", false, "validate and report what would change without writing anything")
+	flag.Parse()
+
+	mode, err := repository.ParseImportMode(*modeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *format == "" {
+		*format = "geojson"
+		if strings.EqualFold(filepath.Ext(*filePath), ".csv") {
+			*format = "csv"
+		}
+	}
+	if *format != "geojson" && *format != "csv" {
+		log.Fatalf("Unknown format %q: use geojson or csv", *format)
+	}
+
+	// A mapping that is neither a named mapping nor a JSON object is the path
+	// of a JSON file, so a file called default or ccd cannot shadow those
+	switch name := strings.ToLower(strings.TrimSpace(*mappingValue)); {
+	case name == "", name == "default", name == "ccd", strings.HasPrefix(name, "{"):
+	default:
+		data, err := os.ReadFile(*mappingValue)
+		if err != nil {
+			log.Fatalf("Failed to read mapping file: %v", err)
+		}
+		*mappingValue = string(data)
+	}
+	mapping, err := repository.ParseCSVMapping(*mappingValue)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Load environment variables
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using environment variables")
+	}
+
+	// Initialize database
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.CloseDB()
+
+	// Create tables
+	if err := database.CreateTables(); err != nil {
+		log.Fatalf("Failed to create tables: %v", err)
+	}
+
+	// Create repository
+	schoolRepo := repository.NewSchoolRepository(database.DB)
+
+	// Import the file
+	if *dryRun {
+		log.Printf("Dry run: no changes will be saved")
+	}
+	log.Printf("Importing schools from %s (%s, %s)", *filePath, *format, mode)
+	opts := repository.ImportOptions{Mode: mode, DryRun: *dryRun}
+
+	var stats models.ImportStats
+	switch *format {
+	case "geojson":
+		stats, err = schoolRepo.ImportFromGeoJSON(*filePath, opts)
+	case "csv":
+		stats, err = importCSV(schoolRepo, *filePath, mapping, opts)
+	}
+	if err != nil {
+		log.Fatalf("Import failed after %d features: %v", stats.Processed, err)
+	}
+
+	log.Printf("Processed %d features: %d inserted, %d updated, %d unchanged, %d deleted",
+		stats.Processed, stats.Inserted, stats.Updated, stats.Unchanged, stats.Deleted)
+	log.Printf("Skipped %d duplicates and %d non-point features; %d failed validation, %d failed to write",
+		stats.SkippedDuplicate, stats.SkippedNonPoint, stats.FailedValidation, stats.Failed)
+	for _, issue := range stats.Issues {
+		log.Printf("  #%d (objectid %d): %s", issue.Index, issue.ObjectID, issue.Reason)
+	}
+}
+
+// importCSV imports schools from a CSV file
+func importCSV(repo *repository.SchoolRepository, filePath string, mapping repository.CSVMapping, opts repository.ImportOptions) (models.ImportStats, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return models.ImportStats{}, err
+	}
+	defer file.Close()
+
+	return repo.ImportFromCSV(bufio.NewReader(file), mapping, opts)
+}
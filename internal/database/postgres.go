@@ -104,9 +104,114 @@ func CreateTables() error {
 	
 	-- Create index on geometry column
 	CREATE INDEX IF NOT EXISTS schools_location_idx ON schools USING GIST(location);
+
+	-- Index geography distances for nearest school searches
+	CREATE INDEX IF NOT EXISTS schools_location_geog_idx ON schools USING GIST((location::geography));
 	
 	-- Create index on objectid
 	CREATE INDEX IF NOT EXISTS schools_objectid_idx ON schools(objectid);
+
+	-- Make ncesid unique so sources without an objectid, such as the Common
+	-- Core of Data, can be imported keyed on it. Existing duplicates fail
+	-- the migration, listing the first of them, until they are resolved.
+	DO $$
+	DECLARE
+		duplicates TEXT;
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'schools_ncesid_key') THEN
+			SELECT string_agg(ncesid || ' (' || n || ' schools)', ', ') INTO duplicates
+			FROM (
+				SELECT ncesid, COUNT(*) AS n FROM schools
+				WHERE ncesid IS NOT NULL
+				GROUP BY ncesid HAVING COUNT(*) > 1
+				ORDER BY ncesid LIMIT 20
+			) d;
+			IF duplicates IS NOT NULL THEN
+				RAISE EXCEPTION 'schools has duplicate ncesid values, which must be resolved before ncesid can be made unique: %', duplicates;
+			END IF;
+			CREATE UNIQUE INDEX schools_ncesid_key ON schools(ncesid);
+		END IF;
+	END $$;
+
+	-- Index the most common list filters
+	CREATE INDEX IF NOT EXISTS schools_state_idx ON schools(lower(state));
+	CREATE INDEX IF NOT EXISTS schools_level_idx ON schools(lower(level));
+
+	-- Add grade span ordinals: PK is -1, KG is 0 and the numbered grades are
+	-- 1 through 13. Ungraded, adult education and not applicable are NULL.
+	CREATE OR REPLACE FUNCTION school_grade_ordinal(code TEXT) RETURNS SMALLINT
+	LANGUAGE SQL IMMUTABLE AS $$
+		SELECT CASE
+			WHEN upper(trim(code)) = 'PK' THEN -1
+			WHEN upper(trim(code)) = 'KG' THEN 0
+			WHEN trim(code) ~ '^[0-9]{1,2}$' AND trim(code)::INTEGER BETWEEN 1 AND 13 THEN trim(code)::SMALLINT
+		END
+	$$;
+
+	ALTER TABLE schools
+		ADD COLUMN IF NOT EXISTS st_grade_ord SMALLINT GENERATED ALWAYS AS (school_grade_ordinal(st_grade)) STORED;
+
+	ALTER TABLE schools
+		ADD COLUMN IF NOT EXISTS end_grade_ord SMALLINT GENERATED ALWAYS AS (school_grade_ordinal(end_grade)) STORED;
+
+	-- Add search columns: a lowercased name, city and address for trigram
+	-- matching and the matching full-text vector
+	CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+	ALTER TABLE schools
+		ADD COLUMN IF NOT EXISTS search_text TEXT GENERATED ALWAYS AS (
+			lower(name || ' ' || coalesce(city, '') || ' ' || coalesce(address, ''))
+		) STORED;
+
+	ALTER TABLE schools
+		ADD COLUMN IF NOT EXISTS search_vector TSVECTOR GENERATED ALWAYS AS (
+			to_tsvector('simple', coalesce(name, '') || ' ' || coalesce(city, '') || ' ' || coalesce(address, ''))
+		) STORED;
+
+	CREATE INDEX IF NOT EXISTS schools_search_vector_idx ON schools USING GIN(search_vector);
+	CREATE INDEX IF NOT EXISTS schools_search_text_trgm_idx ON schools USING GIN(search_text gin_trgm_ops);
+
+	-- Create trigram index on name for autocomplete prefix matching
+	CREATE INDEX IF NOT EXISTS schools_name_trgm_idx ON schools USING GIN(lower(name) gin_trgm_ops);
+
+	-- Create pattern index on name for short autocomplete prefixes
+	CREATE INDEX IF NOT EXISTS schools_name_prefix_idx ON schools(lower(name) text_pattern_ops);
+
+	-- Create import jobs table
+	CREATE TABLE IF NOT EXISTS import_jobs (
+		id SERIAL PRIMARY KEY,
+		status TEXT NOT NULL,
+		source TEXT,
+		processed INTEGER NOT NULL DEFAULT 0,
+		inserted INTEGER NOT NULL DEFAULT 0,
+		skipped INTEGER NOT NULL DEFAULT 0,
+		failed INTEGER NOT NULL DEFAULT 0,
+		errors JSONB NOT NULL DEFAULT '[]',
+		error TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		started_at TIMESTAMP,
+		finished_at TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Add columns for import modes
+	ALTER TABLE import_jobs
+		ADD COLUMN IF NOT EXISTS mode TEXT NOT NULL DEFAULT 'insert-only',
+		ADD COLUMN IF NOT EXISTS updated INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS unchanged INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS deleted INTEGER NOT NULL DEFAULT 0;
+
+	-- Replace the skipped count and error samples with the import report
+	ALTER TABLE import_jobs
+		ADD COLUMN IF NOT EXISTS skipped_duplicate INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS skipped_non_point INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS failed_validation INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS issues JSONB NOT NULL DEFAULT '[]',
+		DROP COLUMN IF EXISTS skipped,
+		DROP COLUMN IF EXISTS errors;
+
+	-- Add dry run flag
+	ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS dry_run BOOLEAN NOT NULL DEFAULT FALSE;
 	`)
 	
 	if err != nil {
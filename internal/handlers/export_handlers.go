@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pistolricks/api-clients/internal/repository"
+)
+
+// exportFormat describes how an export format is served
+type exportFormat struct {
+	contentType string
+	extension   string
+
+	// file formats are written to a temporary file before they are sent
+	file bool
+}
+
+// exportFormats are the formats served by ExportSchools
+var exportFormats = map[string]exportFormat{
+	repository.ExportCSV:        {"text/csv; charset=utf-8", "csv", false},
+	repository.ExportNDJSON:     {"application/x-ndjson", "ndjson", false},
+	repository.ExportGeoJSON:    {geoJSONContentType, "geojson", false},
+	repository.ExportKML:        {"application/vnd.google-earth.kml+xml", "kml", false},
+	repository.ExportGeoPackage: {"application/geopackage+sqlite3", "gpkg", true},
+	repository.ExportShapefile:  {"application/zip", "zip", true},
+}
+
+// ExportSchools handles GET requests to download every school matching the
+// list filters. Schools are streamed from the database to the response as
+// they are read, except for file formats such as GeoPackage and Shapefile.
+func (h *SchoolHandler) ExportSchools(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	formatName := query.Get("format")
+	if formatName == "" {
+		formatName = repository.ExportCSV
+	}
+	format, ok := exportFormats[formatName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid format %q", formatName), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseSchoolFilter(query)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if bboxParam := query.Get("bbox"); bboxParam != "" {
+		bbox, err := parseBBox(bboxParam)
+		if err != nil {
+			http.Error(w, "Invalid bbox: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.BBox = &bbox
+	}
+
+	sort, err := parseSchoolSort(r)
+	if err != nil {
+		http.Error(w, "Invalid sort: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Large exports outlast the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	filename := fmt.Sprintf("schools-%s.%s", time.Now().Format("20060102"), format.extension)
+	if format.file {
+		h.exportFile(w, r, formatName, filename, filter, sort)
+		return
+	}
+
+	buffered := bufio.NewWriterSize(w, 64*1024)
+	var writer repository.SchoolWriter
+	if formatName == repository.ExportKML {
+		writer, err = repository.NewKMLWriter(buffered, query.Get("group"))
+		if err != nil {
+			http.Error(w, "Invalid group: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		writer, err = repository.NewSchoolWriter(formatName, buffered)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// Once streaming has started the status can no longer be changed, so a
+	// failure leaves the download truncated
+	_, err = h.Repo.ExportSchools(filter, sort, writer)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		log.Printf("Export failed: %v", err)
+	}
+}
+
+// exportFile writes the export to a temporary file and sends it once it is
+// complete, so failures are still reported with an error status
+func (h *SchoolHandler) exportFile(w http.ResponseWriter, r *http.Request, formatName, filename string, filter repository.SchoolFilter, sort repository.SchoolSort) {
+	dir, err := os.MkdirTemp("", "schools-export-")
+	if err != nil {
+		http.Error(w, "Error creating export: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, filename)
+	writer, err := repository.NewSchoolFileWriter(formatName, path)
+	if err != nil {
+		http.Error(w, "Error creating export: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.Repo.ExportSchools(filter, sort, writer); err != nil {
+		http.Error(w, "Error exporting schools: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "Error reading export: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", exportFormats[formatName].contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	http.ServeContent(w, r, filename, time.Now(), file)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pistolricks/api-clients/internal/models"
+	"github.com/pistolricks/api-clients/internal/repository"
+)
+
+// GetSchoolFacets handles GET requests for the number of schools per value
+// of each facet, for the schools matching the list filters
+func (h *SchoolHandler) GetSchoolFacets(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, err := parseSchoolFilter(query)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if bboxParam := query.Get("bbox"); bboxParam != "" {
+		bbox, err := parseBBox(bboxParam)
+		if err != nil {
+			http.Error(w, "Invalid bbox: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.BBox = &bbox
+	}
+
+	facets := queryList(query, "facets")
+	if len(facets) == 0 {
+		facets = repository.FacetNames
+	}
+
+	total, counts, err := h.Repo.Facets(filter, facets)
+	if errors.Is(err, repository.ErrUnknownFacet) {
+		http.Error(w, "Invalid facets: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error counting facets: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Total  int                            `json:"total"`
+		Facets map[string][]models.FacetCount `json:"facets"`
+	}{total, counts}
+
+	// Write response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// geoJSONContentType is the RFC 7946 media type
+const geoJSONContentType = "application/geo+json"
+
+// wantsGeoJSON reports whether the client asked for GeoJSON, either with
+// ?format=geojson or an Accept header listing application/geo+json
+func wantsGeoJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return strings.EqualFold(format, "geojson")
+	}
+	return strings.Contains(r.Header.Get("Accept"), geoJSONContentType)
+}
+
+// writeGeoJSON writes v with the GeoJSON content type
+func writeGeoJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", geoJSONContentType)
+	json.NewEncoder(w).Encode(v)
+}
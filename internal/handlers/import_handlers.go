@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pistolricks/api-clients/internal/models"
+	"github.com/pistolricks/api-clients/internal/repository"
+)
+
+// DefaultMaxImportBytes is the default size limit for uploaded GeoJSON
+const DefaultMaxImportBytes = 1 << 30
+
+// importIdleTimeout is how long an upload may go without sending data
+// before its connection is closed
+const importIdleTimeout = time.Minute
+
+// progressDeadlineReader pushes the connection's read deadline back after
+// each read that returns data, so an upload has as long as it keeps sending
+type progressDeadlineReader struct {
+	io.ReadCloser
+	rc *http.ResponseController
+}
+
+func (d progressDeadlineReader) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	if n > 0 {
+		d.rc.SetReadDeadline(time.Now().Add(importIdleTimeout))
+	}
+	return n, err
+}
+
+// importSourceError is an error opening an import source, carrying the
+// HTTP status to respond with
+type importSourceError struct {
+	status  int
+	message string
+}
+
+func (e *importSourceError) Error() string {
+	return e.message
+}
+
+// openImportSource returns the data to import for the request. It is read
+// from the file named by ?path= inside ImportDir, from the "file" part of a
+// multipart/form-data upload, or from the raw request body, in that order.
+// Uploads are limited to MaxImportBytes.
+func (h *SchoolHandler) openImportSource(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	if path := r.URL.Query().Get("path"); path != "" {
+		return h.openImportFile(path)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxImportBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return nil, &importSourceError{http.StatusBadRequest, "Invalid multipart body: " + err.Error()}
+		}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil, &importSourceError{http.StatusBadRequest, `Multipart body has no "file" part`}
+			}
+			if err != nil {
+				return nil, &importSourceError{http.StatusBadRequest, "Invalid multipart body: " + err.Error()}
+			}
+			if part.FormName() == "file" {
+				return part, nil
+			}
+			part.Close()
+		}
+	}
+
+	// Peek so an empty body is reported instead of a JSON decoding error
+	body := bufio.NewReader(r.Body)
+	if _, err := body.Peek(1); err == io.EOF {
+		return nil, &importSourceError{http.StatusBadRequest, "Provide GeoJSON as the request body, a multipart \"file\" upload, or a path"}
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{body, r.Body}, nil
+}
+
+// openImportFile opens a file inside ImportDir. The path is cleaned as if
+// rooted and its symlinks are resolved, so it cannot escape the import
+// directory.
+func (h *SchoolHandler) openImportFile(path string) (io.ReadCloser, error) {
+	if h.ImportDir == "" {
+		return nil, &importSourceError{http.StatusForbidden, "Server-side import paths are disabled"}
+	}
+
+	importDir, err := filepath.EvalSymlinks(h.ImportDir)
+	if err != nil {
+		return nil, &importSourceError{http.StatusInternalServerError, "Error resolving import directory: " + err.Error()}
+	}
+	fullPath, err := filepath.EvalSymlinks(filepath.Join(importDir, filepath.Clean("/"+path)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &importSourceError{http.StatusNotFound, fmt.Sprintf("Import file %q not found", path)}
+		}
+		return nil, &importSourceError{http.StatusInternalServerError, "Error opening import file: " + err.Error()}
+	}
+	if rel, err := filepath.Rel(importDir, fullPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, &importSourceError{http.StatusForbidden, fmt.Sprintf("Import path %q is outside the import directory", path)}
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &importSourceError{http.StatusNotFound, fmt.Sprintf("Import file %q not found", path)}
+		}
+		return nil, &importSourceError{http.StatusInternalServerError, "Error opening import file: " + err.Error()}
+	}
+
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		file.Close()
+		return nil, &importSourceError{http.StatusBadRequest, fmt.Sprintf("Import path %q is not a file", path)}
+	}
+
+	return file, nil
+}
+
+// importErrorStatus picks the HTTP status for an import failure
+func importErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, repository.ErrInvalidGeoJSON) || errors.Is(err, repository.ErrInvalidCSV) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// importQueueSize is how many import jobs may wait for the import worker
+const importQueueSize = 16
+
+// Import file formats
+const (
+	importFormatGeoJSON = "geojson"
+	importFormatCSV     = "csv"
+)
+
+// importTask is a queued import job and the file it reads from
+type importTask struct {
+	job  *models.ImportJob
+	path string
+	opts repository.ImportOptions
+
+	// format is importFormatGeoJSON or importFormatCSV; mapping is used for CSV
+	format  string
+	mapping repository.CSVMapping
+
+	// temporary is set when path is a spooled upload to delete afterwards
+	temporary bool
+}
+
+// discard removes the spooled upload of a task that will not run
+func (t importTask) discard() {
+	if t.temporary {
+		os.Remove(t.path)
+	}
+}
+
+// spoolImportSource returns a task reading from source. Files opened from
+// the import directory are read in place; anything else is copied to a
+// temporary file.
+func spoolImportSource(source io.Reader) (importTask, error) {
+	if file, ok := source.(*os.File); ok {
+		return importTask{path: file.Name()}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "school-import-*")
+	if err != nil {
+		return importTask{}, err
+	}
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, source); err != nil {
+		os.Remove(tmp.Name())
+		return importTask{}, err
+	}
+
+	return importTask{path: tmp.Name(), temporary: true}, nil
+}
+
+// importFormat picks the format of an import from ?format=, or else from the
+// file name or content type of the source
+func importFormat(r *http.Request, source io.Reader) (string, error) {
+	switch format := strings.ToLower(r.URL.Query().Get("format")); format {
+	case importFormatGeoJSON, importFormatCSV:
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unknown import format %q: use geojson or csv", format)
+	}
+
+	var name, contentType string
+	switch source := source.(type) {
+	case *os.File:
+		name = source.Name()
+	case *multipart.Part:
+		name = source.FileName()
+		contentType = source.Header.Get("Content-Type")
+	default:
+		contentType = r.Header.Get("Content-Type")
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if strings.EqualFold(filepath.Ext(name), ".csv") || mediaType == "text/csv" {
+		return importFormatCSV, nil
+	}
+	return importFormatGeoJSON, nil
+}
+
+// importSourceName describes where an import request reads its data from
+func importSourceName(r *http.Request, source io.Reader) string {
+	switch source := source.(type) {
+	case *os.File:
+		return "path:" + r.URL.Query().Get("path")
+	case *multipart.Part:
+		return "upload:" + source.FileName()
+	}
+	return "request body"
+}
+
+// enqueueImport records a job for the task and hands it to the import worker
+func (h *SchoolHandler) enqueueImport(task importTask, source string) (*models.ImportJob, error) {
+	job, err := h.Repo.CreateImportJob(source, task.opts)
+	if err != nil {
+		return nil, err
+	}
+	task.job = job
+
+	select {
+	case h.importQueue <- task:
+		return job, nil
+	default:
+		err := errors.New("too many imports are queued")
+		h.Repo.FinishImportJob(job.ID, models.ImportStats{}, err)
+		return nil, err
+	}
+}
+
+// runImportWorker runs queued import jobs one at a time
+func (h *SchoolHandler) runImportWorker() {
+	for task := range h.importQueue {
+		h.runImport(task)
+	}
+}
+
+// runImport imports the task's file, recording progress on its job
+func (h *SchoolHandler) runImport(task importTask) {
+	defer task.discard()
+	job := task.job
+
+	if err := h.Repo.StartImportJob(job.ID); err != nil {
+		log.Printf("Import job %d: %v", job.ID, err)
+	}
+
+	stats, err := h.importFile(job.ID, task)
+	if err != nil {
+		log.Printf("Import job %d failed: %v", job.ID, err)
+	}
+
+	if err := h.Repo.FinishImportJob(job.ID, stats, err); err != nil {
+		log.Printf("Import job %d: %v", job.ID, err)
+	}
+}
+
+// importFile imports the task's file, storing progress on the job after
+// each batch
+func (h *SchoolHandler) importFile(jobID int64, task importTask) (models.ImportStats, error) {
+	file, err := os.Open(task.path)
+	if err != nil {
+		return models.ImportStats{}, fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+
+	opts := task.opts
+	opts.Progress = func(stats models.ImportStats) {
+		if err := h.Repo.UpdateImportJobProgress(jobID, stats); err != nil {
+			log.Printf("Import job %d: %v", jobID, err)
+		}
+	}
+
+	if task.format == importFormatCSV {
+		return h.Repo.ImportFromCSV(bufio.NewReader(file), task.mapping, opts)
+	}
+	return h.Repo.ImportGeoJSONStream(bufio.NewReader(file), opts)
+}
+
+// GetImportJob handles GET requests to retrieve an import job's progress
+func (h *SchoolHandler) GetImportJob(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid import job ID", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.Repo.GetImportJob(id)
+	if err != nil {
+		http.Error(w, "Error retrieving import job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if job == nil {
+		http.Error(w, "Import job not found", http.StatusNotFound)
+		return
+	}
+
+	// Write response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
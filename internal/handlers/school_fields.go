@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/pistolricks/api-clients/internal/models"
+	"github.com/pistolricks/api-clients/internal/repository"
+)
+
+// parseSchoolFields reads the sparse fieldset from the fields query
+// parameter. It returns nil, selecting every field, when none are given.
+func parseSchoolFields(query url.Values) ([]string, error) {
+	fields := queryList(query, "fields")
+	for _, field := range fields {
+		if !models.IsSchoolField(field) {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+	}
+	return fields, nil
+}
+
+// selectFields returns the fields to read from the repository for a
+// response. GeoJSON geometry needs the coordinates even when they were not
+// asked for.
+func selectFields(r *http.Request, fields []string) []string {
+	if fields == nil || !wantsGeoJSON(r) {
+		return fields
+	}
+	selected := append([]string(nil), fields...)
+	for _, field := range []string{"latitude", "longitude"} {
+		if !slices.Contains(selected, field) {
+			selected = append(selected, field)
+		}
+	}
+	return selected
+}
+
+// schoolResponse converts a school to its response object narrowed to
+// fields, or the full SchoolResponse when fields is nil
+func schoolResponse(school *models.School, fields []string) (interface{}, error) {
+	response := school.ToResponse()
+	if fields == nil {
+		return response, nil
+	}
+	return response.SelectFields(fields)
+}
+
+// schoolResponses converts schools with schoolResponse
+func schoolResponses(schools []*models.School, fields []string) ([]interface{}, error) {
+	responses := make([]interface{}, len(schools))
+	for i, school := range schools {
+		response, err := schoolResponse(school, fields)
+		if err != nil {
+			return nil, err
+		}
+		responses[i] = response
+	}
+	return responses, nil
+}
+
+// schoolFeature converts a school to a GeoJSON feature whose properties are
+// narrowed to fields
+func schoolFeature(school *models.School, fields []string) repository.GeoJSONFeature {
+	feature := repository.FeatureFromSchool(school)
+	if fields != nil {
+		for name := range feature.Properties {
+			if !slices.Contains(fields, name) {
+				delete(feature.Properties, name)
+			}
+		}
+	}
+	return feature
+}
+
+// schoolFeatureCollection converts schools with schoolFeature
+func schoolFeatureCollection(schools []*models.School, fields []string) repository.GeoJSONFeatureCollection {
+	collection := repository.NewFeatureCollection(nil)
+	collection.Features = make([]repository.GeoJSONFeature, len(schools))
+	for i, school := range schools {
+		collection.Features[i] = schoolFeature(school, fields)
+	}
+	return collection
+}
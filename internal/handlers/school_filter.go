@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pistolricks/api-clients/internal/models"
+	"github.com/pistolricks/api-clients/internal/repository"
+)
+
+// parseSchoolFilter reads the attribute filters from the query string. List
+// filters accept comma separated values, repeated parameters, or both.
+func parseSchoolFilter(query url.Values) (repository.SchoolFilter, error) {
+	filter := repository.SchoolFilter{
+		State:      queryList(query, "state"),
+		County:     queryList(query, "county"),
+		CountyFIPS: queryList(query, "countyfips"),
+		City:       queryList(query, "city"),
+		Zip:        queryList(query, "zip"),
+		Level:      queryList(query, "level"),
+		DistrictID: queryList(query, "districtid"),
+	}
+
+	var err error
+	if filter.Status, err = queryIntList(query, "status"); err != nil {
+		return filter, err
+	}
+	if filter.Type, err = queryIntList(query, "type"); err != nil {
+		return filter, err
+	}
+	if filter.EnrollmentMin, err = queryInt(query, "enrollment_min"); err != nil {
+		return filter, err
+	}
+	if filter.EnrollmentMax, err = queryInt(query, "enrollment_max"); err != nil {
+		return filter, err
+	}
+	if filter.TeacherMin, err = queryInt(query, "ft_teacher_min"); err != nil {
+		return filter, err
+	}
+	if filter.TeacherMax, err = queryInt(query, "ft_teacher_max"); err != nil {
+		return filter, err
+	}
+	if filter.Grades, err = queryGrades(query); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// parseSchoolSort reads the sort order from the sort query parameter. The
+// lat and lon parameters give the reference point for sorting by distance.
+func parseSchoolSort(r *http.Request) (repository.SchoolSort, error) {
+	query := r.URL.Query()
+
+	origin, err := parseOptionalPoint(query.Get("lat"), query.Get("lon"))
+	if err != nil {
+		return repository.SchoolSort{}, err
+	}
+	return repository.ParseSchoolSort(query.Get("sort"), origin)
+}
+
+// queryList returns the non-empty values of a list parameter
+func queryList(query url.Values, name string) []string {
+	var values []string
+	for _, param := range query[name] {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// queryIntList returns the values of an integer list parameter
+func queryIntList(query url.Values, name string) ([]int64, error) {
+	var values []int64
+	for _, value := range queryList(query, name) {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", name, value)
+		}
+		values = append(values, n)
+	}
+	return values, nil
+}
+
+// queryInt returns an integer parameter, or nil when it is absent
+func queryInt(query url.Values, name string) (*int64, error) {
+	value := strings.TrimSpace(query.Get(name))
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return &n, nil
+}
+
+// queryGrades returns the grade span from grade=7 or grades=K-2, or nil when
+// neither is given
+func queryGrades(query url.Values) (*repository.GradeSpan, error) {
+	if value := strings.TrimSpace(query.Get("grade")); value != "" {
+		grade, ok := models.ParseGrade(value)
+		if !ok {
+			return nil, fmt.Errorf("invalid grade %q", value)
+		}
+		return &repository.GradeSpan{Low: grade, High: grade}, nil
+	}
+
+	value := strings.TrimSpace(query.Get("grades"))
+	if value == "" {
+		return nil, nil
+	}
+	low, high, found := strings.Cut(value, "-")
+	if !found {
+		high = low
+	}
+	span := repository.GradeSpan{}
+	var okLow, okHigh bool
+	span.Low, okLow = models.ParseGrade(low)
+	span.High, okHigh = models.ParseGrade(high)
+	if !okLow || !okHigh || span.Low > span.High {
+		return nil, fmt.Errorf("invalid grades %q", value)
+	}
+	return &span, nil
+}
@@ -2,25 +2,70 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/pistolricks/api-clients/internal/models"
 	"github.com/pistolricks/api-clients/internal/repository"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 // SchoolHandler handles HTTP requests for schools
 type SchoolHandler struct {
 	Repo *repository.SchoolRepository
+
+	// ImportDir is the directory server-side import paths are resolved in.
+	// Importing by path is disabled when it is empty.
+	ImportDir string
+
+	// MaxImportBytes limits the size of uploaded GeoJSON
+	MaxImportBytes int64
+
+	// importQueue feeds queued import jobs to the import worker
+	importQueue chan importTask
 }
 
-// NewSchoolHandler creates a new SchoolHandler
+// NewSchoolHandler creates a new SchoolHandler and starts its import worker
 func NewSchoolHandler(repo *repository.SchoolRepository) *SchoolHandler {
-	return &SchoolHandler{Repo: repo}
+	h := &SchoolHandler{
+		Repo:           repo,
+		MaxImportBytes: DefaultMaxImportBytes,
+		importQueue:    make(chan importTask, importQueueSize),
+	}
+	go h.runImportWorker()
+	return h
 }
 
 // GetSchools handles GET requests to list schools
 func (h *SchoolHandler) GetSchools(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseSchoolFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sort, err := parseSchoolSort(r)
+	if err != nil {
+		http.Error(w, "Invalid sort: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fields, err := parseSchoolFields(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid fields: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := repository.ListQuery{Filter: filter, Sort: sort, Fields: selectFields(r, fields)}
+
+	// A bbox filter switches to a viewport query instead of paging
+	if bboxParam := r.URL.Query().Get("bbox"); bboxParam != "" {
+		h.getSchoolsInBBox(w, r, bboxParam, q, fields)
+		return
+	}
+
 	// Parse query parameters for pagination
 	page, err := strconv.Atoi(r.URL.Query().Get("page"))
 	if err != nil || page < 1 {
@@ -32,34 +77,57 @@ func (h *SchoolHandler) GetSchools(w http.ResponseWriter, r *http.Request) {
 		pageSize = 200
 	}
 
+	// A cursor from a previous response takes precedence over page
+	q.Cursor = r.URL.Query().Get("cursor")
+	q.Page = page
+	q.PageSize = pageSize
+
 	// Get schools from repository
-	schools, err := h.Repo.List(page, pageSize)
+	schools, nextCursor, err := h.Repo.List(q)
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Error retrieving schools: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Get total count for pagination
-	count, err := h.Repo.Count()
+	count, err := h.Repo.Count(filter)
 	if err != nil {
 		http.Error(w, "Error counting schools: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if wantsGeoJSON(r) {
+		// Paging details are carried as foreign members of the collection
+		writeGeoJSON(w, struct {
+			repository.GeoJSONFeatureCollection
+			Total      int    `json:"total"`
+			Page       int    `json:"page"`
+			PageSize   int    `json:"pageSize"`
+			NextCursor string `json:"next_cursor,omitempty"`
+		}{schoolFeatureCollection(schools, fields), count, page, pageSize, nextCursor})
+		return
+	}
+
 	// Convert to response objects
 	var response struct {
-		Schools  []models.SchoolResponse `json:"schools"`
-		Total    int                     `json:"total"`
-		Page     int                     `json:"page"`
-		PageSize int                     `json:"pageSize"`
+		Schools    []interface{} `json:"schools"`
+		Total      int           `json:"total"`
+		Page       int           `json:"page"`
+		PageSize   int           `json:"pageSize"`
+		NextCursor string        `json:"next_cursor,omitempty"`
 	}
 	response.Total = count
 	response.Page = page
 	response.PageSize = pageSize
-	response.Schools = make([]models.SchoolResponse, len(schools))
-
-	for i, school := range schools {
-		response.Schools[i] = school.ToResponse()
+	response.NextCursor = nextCursor
+	response.Schools, err = schoolResponses(schools, fields)
+	if err != nil {
+		http.Error(w, "Error encoding schools: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	// Write response
@@ -77,8 +145,14 @@ func (h *SchoolHandler) GetSchool(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	fields, err := parseSchoolFields(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid fields: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Get school from repository
-	school, err := h.Repo.GetByID(id)
+	school, err := h.Repo.GetFieldsByID(id, selectFields(r, fields))
 	if err != nil {
 		http.Error(w, "Error retrieving school: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -89,8 +163,17 @@ func (h *SchoolHandler) GetSchool(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if wantsGeoJSON(r) {
+		writeGeoJSON(w, schoolFeature(school, fields))
+		return
+	}
+
 	// Convert to response object
-	response := school.ToResponse()
+	response, err := schoolResponse(school, fields)
+	if err != nil {
+		http.Error(w, "Error encoding school: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	// Write response
 	w.Header().Set("Content-Type", "application/json")
@@ -492,26 +575,80 @@ func (h *SchoolHandler) DeleteSchool(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// ImportGeoJSON handles POST requests to import schools from a GeoJSON file
+// ImportGeoJSON handles POST requests to import schools from GeoJSON or CSV.
+// See openImportSource for where the data is read from. The import runs
+// in the background; the response is the queued job, whose progress can be
+// followed with GetImportJob.
 func (h *SchoolHandler) ImportGeoJSON(w http.ResponseWriter, r *http.Request) {
-	// Import from GeoJSON file
-	// Use absolute path to the file in the project root
-	count, err := h.Repo.ImportFromGeoJSON("./us-public-schools-part1.geojson")
+	mode, err := repository.ParseImportMode(r.URL.Query().Get("mode"))
 	if err != nil {
-		http.Error(w, "Error importing schools: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Return success
-	response := struct {
-		Message string `json:"message"`
-		Count   int    `json:"count"`
-	}{
-		Message: "Schools imported successfully",
-		Count:   count,
+	mapping, err := repository.ParseCSVMapping(r.URL.Query().Get("mapping"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var dryRun bool
+	if dryRunParam := r.URL.Query().Get("dry_run"); dryRunParam != "" {
+		if dryRun, err = strconv.ParseBool(dryRunParam); err != nil {
+			http.Error(w, "Invalid dry_run", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Large uploads can take longer than the server's read and write
+	// timeouts. The read deadline is extended while the upload makes
+	// progress, so a stalled client still times out; the write deadline also
+	// runs while the upload is spooled, so without clearing it the client
+	// would lose the job ID.
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Now().Add(importIdleTimeout))
+	rc.SetWriteDeadline(time.Time{})
+	r.Body = progressDeadlineReader{ReadCloser: r.Body, rc: rc}
+
+	source, err := h.openImportSource(w, r)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if sourceErr, ok := err.(*importSourceError); ok {
+			status = sourceErr.status
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer source.Close()
+
+	format, err := importFormat(r, source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Uploads are spooled to disk so the job can outlive the request
+	task, err := spoolImportSource(source)
+	if err != nil {
+		http.Error(w, "Error reading import: "+err.Error(), importErrorStatus(err))
+		return
+	}
+	task.opts = repository.ImportOptions{Mode: mode, DryRun: dryRun}
+	task.format = format
+	task.mapping = mapping
+
+	job, err := h.enqueueImport(task, importSourceName(r, source))
+	if err != nil {
+		task.discard()
+		http.Error(w, "Error queueing import: "+err.Error(), http.StatusServiceUnavailable)
+		return
 	}
+
+	// Return the queued job
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	w.Header().Set("Location", fmt.Sprintf("/api/imports/%d", job.ID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
 }
 
 type GeoJSONFeatureCollection struct {
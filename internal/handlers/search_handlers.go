@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+// SearchSchools handles GET requests to search schools by name, city and address
+func (h *SchoolHandler) SearchSchools(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "Query parameter q is required", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	results, err := h.Repo.Search(q, limit)
+	if err != nil {
+		http.Error(w, "Error searching schools: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Convert to response objects
+	var response struct {
+		Schools []models.SearchResultResponse `json:"schools"`
+		Total   int                           `json:"total"`
+	}
+	response.Total = len(results)
+	response.Schools = make([]models.SearchResultResponse, len(results))
+
+	for i, result := range results {
+		response.Schools[i] = models.SearchResultResponse{
+			SchoolResponse: result.School.ToResponse(),
+			Score:          result.Score,
+		}
+	}
+
+	// Write response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// AutocompleteSchools handles GET requests for typeahead school suggestions
+func (h *SchoolHandler) AutocompleteSchools(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		http.Error(w, "Query parameter q is required", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit < 1 || limit > 50 {
+		limit = 10
+	}
+
+	// lat and lon are optional, but must be given together
+	center, err := parseOptionalPoint(query.Get("lat"), query.Get("lon"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	suggestions, err := h.Repo.Autocomplete(q, limit, center)
+	if err != nil {
+		http.Error(w, "Error retrieving suggestions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if suggestions == nil {
+		suggestions = []models.SchoolSuggestion{}
+	}
+
+	// Write response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestions)
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pistolricks/api-clients/internal/models"
+	"github.com/pistolricks/api-clients/internal/repository"
+)
+
+// maxBBoxResults caps the number of schools returned for a single viewport
+const maxBBoxResults = 5000
+
+const (
+	// clusterMaxZoom is the first zoom level at which individual schools are
+	// returned instead of clusters
+	clusterMaxZoom = 12
+
+	// clusterCellsPerTile is how many grid cells span one 256px tile, so
+	// clusters are roughly 32px apart on screen
+	clusterCellsPerTile = 8
+)
+
+// getSchoolsInBBox writes the schools matching the query inside a
+// minLon,minLat,maxLon,maxLat box, narrowed to the response fields
+func (h *SchoolHandler) getSchoolsInBBox(w http.ResponseWriter, r *http.Request, bboxParam string, q repository.ListQuery, fields []string) {
+	bbox, err := parseBBox(bboxParam)
+	if err != nil {
+		http.Error(w, "Invalid bbox: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q.PageSize = maxBBoxResults
+	schools, truncated, err := h.Repo.ListInBBox(bbox, q)
+	if err != nil {
+		http.Error(w, "Error retrieving schools: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Only a truncated box needs counting
+	total := len(schools)
+	if truncated {
+		filter := q.Filter
+		filter.BBox = &bbox
+		if total, err = h.Repo.Count(filter); err != nil {
+			http.Error(w, "Error counting schools: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if wantsGeoJSON(r) {
+		writeGeoJSON(w, struct {
+			repository.GeoJSONFeatureCollection
+			Total     int  `json:"total"`
+			Truncated bool `json:"truncated"`
+		}{schoolFeatureCollection(schools, fields), total, truncated})
+		return
+	}
+
+	// Convert to response objects
+	var response struct {
+		Schools   []interface{} `json:"schools"`
+		Total     int           `json:"total"`
+		Truncated bool          `json:"truncated"`
+	}
+	response.Total = total
+	response.Truncated = truncated
+	response.Schools, err = schoolResponses(schools, fields)
+	if err != nil {
+		http.Error(w, "Error encoding schools: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseBBox parses a minLon,minLat,maxLon,maxLat query value
+func parseBBox(value string) (repository.BoundingBox, error) {
+	var bbox repository.BoundingBox
+
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return bbox, fmt.Errorf("expected minLon,minLat,maxLon,maxLat")
+	}
+
+	coords := make([]float64, 4)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return bbox, fmt.Errorf("invalid coordinate %q", part)
+		}
+		coords[i] = v
+	}
+
+	bbox = repository.BoundingBox{MinLon: coords[0], MinLat: coords[1], MaxLon: coords[2], MaxLat: coords[3]}
+	if bbox.MinLon < -180 || bbox.MaxLon > 180 || bbox.MinLat < -90 || bbox.MaxLat > 90 {
+		return bbox, fmt.Errorf("coordinates out of range")
+	}
+	if bbox.MinLon > bbox.MaxLon || bbox.MinLat > bbox.MaxLat {
+		return bbox, fmt.Errorf("min must not exceed max")
+	}
+
+	return bbox, nil
+}
+
+// GetNearbySchools handles GET requests for the schools closest to a point
+func (h *SchoolHandler) GetNearbySchools(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	lat, err := strconv.ParseFloat(query.Get("lat"), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		http.Error(w, "Invalid or missing lat", http.StatusBadRequest)
+		return
+	}
+
+	lon, err := strconv.ParseFloat(query.Get("lon"), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		http.Error(w, "Invalid or missing lon", http.StatusBadRequest)
+		return
+	}
+
+	// radius_m is optional; without it the nearest schools are returned regardless of distance
+	var radius float64
+	if radiusParam := query.Get("radius_m"); radiusParam != "" {
+		radius, err = strconv.ParseFloat(radiusParam, 64)
+		if err != nil || radius <= 0 {
+			http.Error(w, "Invalid radius_m", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	results, err := h.Repo.Nearby(lat, lon, radius, limit)
+	if err != nil {
+		http.Error(w, "Error retrieving nearby schools: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Convert to response objects
+	var response struct {
+		Schools []models.NearbySchoolResponse `json:"schools"`
+		Total   int                           `json:"total"`
+	}
+	response.Total = len(results)
+	response.Schools = make([]models.NearbySchoolResponse, len(results))
+
+	for i, result := range results {
+		response.Schools[i] = models.NearbySchoolResponse{
+			SchoolResponse: result.School.ToResponse(),
+			DistanceMeters: result.DistanceMeters,
+		}
+	}
+
+	// Write response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetSchoolClusters handles GET requests for clustered schools in a viewport.
+// Past clusterMaxZoom the individual schools are returned instead.
+func (h *SchoolHandler) GetSchoolClusters(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	bbox, err := parseBBox(query.Get("bbox"))
+	if err != nil {
+		http.Error(w, "Invalid bbox: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseSchoolFilter(query)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fields, err := parseSchoolFields(query)
+	if err != nil {
+		http.Error(w, "Invalid fields: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	zoom, err := strconv.Atoi(query.Get("zoom"))
+	if err != nil || zoom < 0 || zoom > maxTileZoom {
+		http.Error(w, "Invalid or missing zoom", http.StatusBadRequest)
+		return
+	}
+
+	var response struct {
+		Mode      string                 `json:"mode"`
+		Clusters  []models.SchoolCluster `json:"clusters,omitempty"`
+		Schools   []interface{}          `json:"schools,omitempty"`
+		Truncated bool                   `json:"truncated,omitempty"`
+	}
+
+	if zoom >= clusterMaxZoom {
+		q := repository.ListQuery{Filter: filter, Fields: selectFields(r, fields), PageSize: maxBBoxResults}
+		schools, truncated, err := h.Repo.ListInBBox(bbox, q)
+		if err != nil {
+			http.Error(w, "Error retrieving schools: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.Mode = "schools"
+		response.Truncated = truncated
+		response.Schools, err = schoolResponses(schools, fields)
+		if err != nil {
+			http.Error(w, "Error encoding schools: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		// One tile spans 360/2^zoom degrees of longitude
+		cellSize := 360 / float64(int(1)<<uint(zoom)) / clusterCellsPerTile
+
+		clusters, err := h.Repo.Clusters(bbox, filter, cellSize)
+		if err != nil {
+			http.Error(w, "Error clustering schools: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.Mode = "clusters"
+		response.Clusters = clusters
+	}
+
+	// Write response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxTileZoom is the deepest zoom level served by the tile endpoint
+const maxTileZoom = 22
+
+// GetSchoolTile handles GET requests for a Mapbox Vector Tile of schools
+func (h *SchoolHandler) GetSchoolTile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	z, errZ := strconv.Atoi(vars["z"])
+	x, errX := strconv.Atoi(vars["x"])
+	y, errY := strconv.Atoi(vars["y"])
+	if errZ != nil || errX != nil || errY != nil || z < 0 || z > maxTileZoom {
+		http.Error(w, "Invalid tile coordinates", http.StatusBadRequest)
+		return
+	}
+
+	// x and y must be inside the 2^z by 2^z tile grid
+	if n := 1 << uint(z); x < 0 || x >= n || y < 0 || y >= n {
+		http.Error(w, "Invalid tile coordinates", http.StatusBadRequest)
+		return
+	}
+
+	var attributes []string
+	if fieldsParam := r.URL.Query().Get("fields"); fieldsParam != "" {
+		attributes = strings.Split(fieldsParam, ",")
+		if err := repository.ValidateTileAttributes(attributes); err != nil {
+			http.Error(w, "Invalid fields: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tile, err := h.Repo.SchoolTile(z, x, y, attributes)
+	if err != nil {
+		http.Error(w, "Error rendering tile: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write response
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(tile)
+}
+
+// parseOptionalPoint parses lat and lon query values. It returns nil when
+// neither is given.
+func parseOptionalPoint(latParam, lonParam string) (*repository.Point, error) {
+	if latParam == "" && lonParam == "" {
+		return nil, nil
+	}
+
+	lat, err := strconv.ParseFloat(latParam, 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return nil, fmt.Errorf("invalid or missing lat")
+	}
+
+	lon, err := strconv.ParseFloat(lonParam, 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return nil, fmt.Errorf("invalid or missing lon")
+	}
+
+	return &repository.Point{Lat: lat, Lon: lon}, nil
+}
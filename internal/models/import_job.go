@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// Import job statuses
+const (
+	ImportJobQueued    = "queued"
+	ImportJobRunning   = "running"
+	ImportJobCompleted = "completed"
+	ImportJobFailed    = "failed"
+)
+
+// MaxImportIssues caps the per-feature problems kept for an import
+const MaxImportIssues = 1000
+
+// ImportIssue is a problem with one feature of an import
+type ImportIssue struct {
+	Index    int    `json:"index"`
+	ObjectID int    `json:"objectid,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// ImportStats counts what happened to the features of an import
+type ImportStats struct {
+	Processed        int           `json:"processed"`
+	Inserted         int           `json:"inserted"`
+	Updated          int           `json:"updated"`
+	Unchanged        int           `json:"unchanged"`
+	Deleted          int           `json:"deleted"`
+	SkippedDuplicate int           `json:"skipped_duplicate"`
+	SkippedNonPoint  int           `json:"skipped_non_point"`
+	FailedValidation int           `json:"failed_validation"`
+	Failed           int           `json:"failed"`
+	Issues           []ImportIssue `json:"issues,omitempty"`
+}
+
+// AddIssue records a problem with the feature at index, keeping at most
+// MaxImportIssues
+func (s *ImportStats) AddIssue(index, objectID int, reason string) {
+	if len(s.Issues) < MaxImportIssues {
+		s.Issues = append(s.Issues, ImportIssue{Index: index, ObjectID: objectID, Reason: reason})
+	}
+}
+
+// ImportJob is an import running in the background and its progress
+type ImportJob struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+	Source string `json:"source"`
+	Mode   string `json:"mode"`
+	DryRun bool   `json:"dry_run"`
+	ImportStats
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
@@ -2,6 +2,10 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -40,6 +44,73 @@ type School struct {
 	ShelterID  sql.NullString `json:"shelter_id"`
 	CreatedAt  time.Time      `json:"created_at"`
 	UpdatedAt  time.Time      `json:"updated_at"`
+
+	// StartGradeOrd and EndGradeOrd are the grade span as ordinals, see
+	// GradeOrdinal. They are generated by the database from the grade codes.
+	StartGradeOrd sql.NullInt64 `json:"st_grade_ord"`
+	EndGradeOrd   sql.NullInt64 `json:"end_grade_ord"`
+}
+
+// Validate checks that the school has the fields needed to store and map
+// it. The key an import matches schools on is checked by the importer.
+func (s *School) Validate() error {
+	if s.Name == "" {
+		return errors.New("missing name")
+	}
+	if s.Latitude < -90 || s.Latitude > 90 || s.Longitude < -180 || s.Longitude > 180 {
+		return errors.New("coordinates out of range")
+	}
+	if s.Latitude == 0 && s.Longitude == 0 {
+		return errors.New("coordinates are 0,0")
+	}
+	if s.StartGrade.Valid && !IsGradeCode(s.StartGrade.String) {
+		return fmt.Errorf("unknown st_grade %q", s.StartGrade.String)
+	}
+	if s.EndGrade.Valid && !IsGradeCode(s.EndGrade.String) {
+		return fmt.Errorf("unknown end_grade %q", s.EndGrade.String)
+	}
+	return nil
+}
+
+// gradeCodes are the grade codes used by the source data: pre-kindergarten,
+// kindergarten, grades 01 through 13, ungraded, adult education and not
+// applicable
+var gradeCodes = map[string]bool{
+	"PK": true, "KG": true, "01": true, "02": true, "03": true, "04": true, "05": true,
+	"06": true, "07": true, "08": true, "09": true, "10": true, "11": true, "12": true,
+	"13": true, "UG": true, "AE": true, "N": true,
+}
+
+// IsGradeCode reports whether code is a known grade code
+func IsGradeCode(code string) bool {
+	return gradeCodes[code]
+}
+
+// GradeOrdinal returns the position of a grade code in school order:
+// -1 for PK, 0 for KG and 1 through 13 for the numbered grades. Ungraded,
+// adult education and not applicable have no ordinal. It matches the
+// school_grade_ordinal database function.
+func GradeOrdinal(code string) (int, bool) {
+	switch code = strings.ToUpper(strings.TrimSpace(code)); code {
+	case "PK":
+		return -1, true
+	case "KG":
+		return 0, true
+	}
+	n, err := strconv.Atoi(code)
+	if err != nil || n < 1 || n > 13 {
+		return 0, false
+	}
+	return n, true
+}
+
+// ParseGrade parses a grade as written by API users: a grade code, K for
+// kindergarten, or a grade number with or without a leading zero
+func ParseGrade(value string) (int, bool) {
+	if strings.EqualFold(strings.TrimSpace(value), "K") {
+		return 0, true
+	}
+	return GradeOrdinal(value)
 }
 
 // SchoolResponse is used for API responses
@@ -77,6 +148,9 @@ type SchoolResponse struct {
 	ShelterID  string    `json:"shelter_id,omitempty"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
+
+	StartGradeOrd *int64 `json:"st_grade_ord,omitempty"`
+	EndGradeOrd   *int64 `json:"end_grade_ord,omitempty"`
 }
 
 // ToResponse converts a School to a SchoolResponse
@@ -121,6 +195,14 @@ func (s *School) ToResponse() SchoolResponse {
 	if s.EndGrade.Valid {
 		response.EndGrade = s.EndGrade.String
 	}
+	if s.StartGradeOrd.Valid {
+		ord := s.StartGradeOrd.Int64
+		response.StartGradeOrd = &ord
+	}
+	if s.EndGradeOrd.Valid {
+		ord := s.EndGradeOrd.Int64
+		response.EndGradeOrd = &ord
+	}
 	if s.Enrollment.Valid {
 		response.Enrollment = s.Enrollment.Int64
 	}
@@ -171,4 +253,41 @@ func (s *School) ToResponse() SchoolResponse {
 	}
 
 	return response
-}
\ No newline at end of file
+}
+
+// NearbySchoolResponse is a SchoolResponse with its distance from a search point
+type NearbySchoolResponse struct {
+	SchoolResponse
+	DistanceMeters float64 `json:"distance_m"`
+}
+
+// SchoolCluster is a group of nearby schools summarized for low zoom levels
+type SchoolCluster struct {
+	Latitude  float64        `json:"latitude"`
+	Longitude float64        `json:"longitude"`
+	Count     int            `json:"count"`
+	Levels    map[string]int `json:"levels"`
+}
+
+// SearchResultResponse is a SchoolResponse with its search relevance score
+type SearchResultResponse struct {
+	SchoolResponse
+	Score float64 `json:"score"`
+}
+
+// SchoolSuggestion is a compact school match for typeahead suggestions
+type SchoolSuggestion struct {
+	ID        int64   `json:"id"`
+	Name      string  `json:"name"`
+	City      string  `json:"city,omitempty"`
+	State     string  `json:"state,omitempty"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+}
+
+// FacetCount is the number of schools with one value of a facet. Value is
+// nil for schools without one.
+type FacetCount struct {
+	Value *string `json:"value"`
+	Count int     `json:"count"`
+}
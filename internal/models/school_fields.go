@@ -0,0 +1,42 @@
+package models
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// SchoolFields are the SchoolResponse fields a sparse fieldset can select
+var SchoolFields = []string{
+	"id", "objectid", "name", "address", "city", "state", "zip", "country", "county", "countyfips",
+	"latitude", "longitude", "level", "st_grade", "end_grade", "enrollment", "ft_teacher",
+	"type", "status", "population", "ncesid", "districtid", "naics_code", "naics_desc",
+	"website", "telephone", "sourcedate", "val_date", "val_method", "source", "shelter_id",
+	"created_at", "updated_at", "st_grade_ord", "end_grade_ord",
+}
+
+// IsSchoolField reports whether name is one of SchoolFields
+func IsSchoolField(name string) bool {
+	return slices.Contains(SchoolFields, name)
+}
+
+// SelectFields returns the response narrowed to the named fields. Fields
+// the response omits because they are empty stay omitted.
+func (r SchoolResponse) SelectFields(fields []string) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			selected[field] = value
+		}
+	}
+	return selected, nil
+}
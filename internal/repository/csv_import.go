@@ -0,0 +1,290 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+// ErrInvalidCSV is returned when the input cannot be read as CSV
+var ErrInvalidCSV = errors.New("invalid CSV")
+
+// CSVMapping maps School fields, named by their JSON names, to the CSV
+// column headers they are read from. Schools are matched on objectid when
+// it is mapped and on ncesid otherwise, see Key.
+type CSVMapping map[string]string
+
+// DefaultCSVMapping reads columns named after the School JSON fields
+var DefaultCSVMapping = CSVMapping{
+	"objectid": "objectid", "name": "name", "address": "address", "city": "city",
+	"state": "state", "zip": "zip", "country": "country", "county": "county",
+	"countyfips": "countyfips", "latitude": "latitude", "longitude": "longitude",
+	"level": "level", "st_grade": "st_grade", "end_grade": "end_grade",
+	"enrollment": "enrollment", "ft_teacher": "ft_teacher", "type": "type",
+	"status": "status", "population": "population", "ncesid": "ncesid",
+	"districtid": "districtid", "naics_code": "naics_code", "naics_desc": "naics_desc",
+	"website": "website", "telephone": "telephone", "sourcedate": "sourcedate",
+	"val_date": "val_date", "val_method": "val_method", "source": "source",
+	"shelter_id": "shelter_id",
+}
+
+// CCDCSVMapping reads NCES Common Core of Data school directory files with
+// the EDGE geocode LAT and LON columns joined on. The files have no
+// objectid, so schools are matched on the NCES school ID.
+var CCDCSVMapping = CSVMapping{
+	"ncesid":     "NCESSCH",
+	"name":       "SCH_NAME",
+	"address":    "LSTREET1",
+	"city":       "LCITY",
+	"state":      "LSTATE",
+	"zip":        "LZIP",
+	"districtid": "LEAID",
+	"telephone":  "PHONE",
+	"website":    "WEBSITE",
+	"level":      "LEVEL",
+	"st_grade":   "GSLO",
+	"end_grade":  "GSHI",
+	"latitude":   "LAT",
+	"longitude":  "LON",
+}
+
+// requiredCSVFields must be mapped to a column present in the file, as
+// must the mapping's key
+var requiredCSVFields = []string{"name", "latitude", "longitude"}
+
+// ParseCSVMapping returns a named mapping ("default" or "ccd") or decodes a
+// JSON object of field to column. The empty string is the default mapping.
+func ParseCSVMapping(value string) (CSVMapping, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "default":
+		return DefaultCSVMapping, nil
+	case "ccd":
+		return CCDCSVMapping, nil
+	}
+
+	var mapping CSVMapping
+	if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+		return nil, fmt.Errorf("invalid CSV mapping: %w", err)
+	}
+	if err := mapping.Validate(); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// Validate checks that every mapped field exists and the required fields are mapped
+func (m CSVMapping) Validate() error {
+	for field := range m {
+		if _, ok := csvFieldSetters[field]; !ok {
+			return fmt.Errorf("invalid CSV mapping: unknown field %q", field)
+		}
+	}
+	if m["objectid"] == "" && m["ncesid"] == "" {
+		return errors.New(`invalid CSV mapping: "objectid" or "ncesid" must be mapped`)
+	}
+	for _, field := range requiredCSVFields {
+		if m[field] == "" {
+			return fmt.Errorf("invalid CSV mapping: %q must be mapped", field)
+		}
+	}
+	return nil
+}
+
+// Key returns the field schools are matched on: objectid when it is mapped
+// and ncesid otherwise
+func (m CSVMapping) Key() ImportKey {
+	if m["objectid"] != "" {
+		return ImportKeyObjectID
+	}
+	return ImportKeyNCESID
+}
+
+// ImportFromCSV imports schools from CSV read from rd. The first record is
+// the header; mapping says which column each School field is read from.
+// Mapped columns missing from the header are ignored, except those of the
+// required fields and the key. Records are validated and written like
+// GeoJSON features, matched on the mapping's key; existing schools are only
+// updated with the fields the file has columns for.
+func (r *SchoolRepository) ImportFromCSV(rd io.Reader, mapping CSVMapping, opts ImportOptions) (models.ImportStats, error) {
+	if err := mapping.Validate(); err != nil {
+		return models.ImportStats{}, err
+	}
+
+	reader := csv.NewReader(rd)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return models.ImportStats{}, fmt.Errorf("%w: error reading header: %v", ErrInvalidCSV, err)
+	}
+
+	// Resolve each mapped field to its column index, ignoring any byte order mark
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	fields := make(map[string]int, len(mapping))
+	for field, column := range mapping {
+		if i, ok := columns[column]; ok {
+			fields[field] = i
+		}
+	}
+	key := mapping.Key()
+	for _, field := range append([]string{string(key)}, requiredCSVFields...) {
+		if _, ok := fields[field]; !ok {
+			return models.ImportStats{}, fmt.Errorf("%w: column %q for %s not found", ErrInvalidCSV, mapping[field], field)
+		}
+	}
+
+	opts.Key = key
+	opts.Fields = make([]string, 0, len(fields))
+	for field := range fields {
+		opts.Fields = append(opts.Fields, field)
+	}
+	importer, err := r.newSchoolImporter(opts)
+	if err != nil {
+		return models.ImportStats{}, err
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return importer.stats, fmt.Errorf("%w: %v", ErrInvalidCSV, err)
+		}
+		index := importer.stats.Processed
+		importer.stats.Processed++
+
+		school, err := schoolFromCSVRecord(record, fields)
+		if err != nil {
+			importer.reject(index, &school, err)
+			continue
+		}
+		if err := importer.add(index, school); err != nil {
+			return importer.stats, err
+		}
+	}
+
+	err = importer.finish()
+	return importer.stats, err
+}
+
+// schoolFromCSVRecord builds a School from the mapped columns of a record.
+// Empty cells are left null.
+func schoolFromCSVRecord(record []string, fields map[string]int) (models.School, error) {
+	var school models.School
+	for field, i := range fields {
+		if i >= len(record) {
+			continue
+		}
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			continue
+		}
+		if err := csvFieldSetters[field](&school, value); err != nil {
+			return school, fmt.Errorf("invalid %s %q", field, value)
+		}
+	}
+	return school, nil
+}
+
+// csvFieldSetters parse a CSV cell into the School field of the same JSON name
+var csvFieldSetters = map[string]func(*models.School, string) error{
+	"objectid": func(s *models.School, v string) error {
+		n, err := parseCSVInt(v)
+		s.ObjectID = int(n)
+		return err
+	},
+	"name":       func(s *models.School, v string) error { s.Name = v; return nil },
+	"address":    csvString(func(s *models.School) *sql.NullString { return &s.Address }),
+	"city":       csvString(func(s *models.School) *sql.NullString { return &s.City }),
+	"state":      csvString(func(s *models.School) *sql.NullString { return &s.State }),
+	"zip":        csvString(func(s *models.School) *sql.NullString { return &s.Zip }),
+	"country":    csvString(func(s *models.School) *sql.NullString { return &s.Country }),
+	"county":     csvString(func(s *models.School) *sql.NullString { return &s.County }),
+	"countyfips": csvString(func(s *models.School) *sql.NullString { return &s.CountyFIPS }),
+	"latitude": func(s *models.School, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		s.Latitude = f
+		return err
+	},
+	"longitude": func(s *models.School, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		s.Longitude = f
+		return err
+	},
+	"level":      csvString(func(s *models.School) *sql.NullString { return &s.Level }),
+	"st_grade":   csvString(func(s *models.School) *sql.NullString { return &s.StartGrade }),
+	"end_grade":  csvString(func(s *models.School) *sql.NullString { return &s.EndGrade }),
+	"enrollment": csvInt(func(s *models.School) *sql.NullInt64 { return &s.Enrollment }),
+	"ft_teacher": csvInt(func(s *models.School) *sql.NullInt64 { return &s.FTTeacher }),
+	"type":       csvInt(func(s *models.School) *sql.NullInt64 { return &s.Type }),
+	"status":     csvInt(func(s *models.School) *sql.NullInt64 { return &s.Status }),
+	"population": csvInt(func(s *models.School) *sql.NullInt64 { return &s.Population }),
+	"ncesid":     csvString(func(s *models.School) *sql.NullString { return &s.NCESID }),
+	"districtid": csvString(func(s *models.School) *sql.NullString { return &s.DistrictID }),
+	"naics_code": csvString(func(s *models.School) *sql.NullString { return &s.NAICSCode }),
+	"naics_desc": csvString(func(s *models.School) *sql.NullString { return &s.NAICSDesc }),
+	"website":    csvString(func(s *models.School) *sql.NullString { return &s.Website }),
+	"telephone":  csvString(func(s *models.School) *sql.NullString { return &s.Telephone }),
+	"sourcedate": csvDate(func(s *models.School) *sql.NullTime { return &s.SourceDate }),
+	"val_date":   csvDate(func(s *models.School) *sql.NullTime { return &s.ValDate }),
+	"val_method": csvString(func(s *models.School) *sql.NullString { return &s.ValMethod }),
+	"source":     csvString(func(s *models.School) *sql.NullString { return &s.Source }),
+	"shelter_id": csvString(func(s *models.School) *sql.NullString { return &s.ShelterID }),
+}
+
+// csvString returns a setter for a nullable text field
+func csvString(field func(*models.School) *sql.NullString) func(*models.School, string) error {
+	return func(s *models.School, v string) error {
+		*field(s) = sql.NullString{String: v, Valid: true}
+		return nil
+	}
+}
+
+// csvInt returns a setter for a nullable integer field
+func csvInt(field func(*models.School) *sql.NullInt64) func(*models.School, string) error {
+	return func(s *models.School, v string) error {
+		n, err := parseCSVInt(v)
+		if err != nil {
+			return err
+		}
+		*field(s) = sql.NullInt64{Int64: n, Valid: true}
+		return nil
+	}
+}
+
+// csvDate returns a setter for a nullable date field
+func csvDate(field func(*models.School) *sql.NullTime) func(*models.School, string) error {
+	return func(s *models.School, v string) error {
+		t, ok := parseDate(v)
+		if !ok {
+			return errors.New("unparseable date")
+		}
+		*field(s) = sql.NullTime{Time: t, Valid: true}
+		return nil
+	}
+}
+
+// parseCSVInt parses a whole number, allowing a zero fraction such as "12.0"
+// as spreadsheets often write
+func parseCSVInt(v string) (int64, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f != float64(int64(f)) {
+		return 0, errors.New("not a whole number")
+	}
+	return int64(f), nil
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// The FlatGeobuf header and features are FlatBuffers tables. The few
+// tables needed are encoded front to back: each table is preceded by its
+// vtable and followed by the strings, vectors and tables it refers to, so
+// every reference is a positive offset as the format requires.
+
+// fbField is a table field: either a little endian scalar or a reference to
+// an object written by child, which returns the object's position
+type fbField struct {
+	slot   int
+	scalar []byte
+	child  func(b *fbBuilder) int
+}
+
+// fbTable is a table given as its fields
+type fbTable []fbField
+
+// fbBuilder accumulates an encoded FlatBuffer
+type fbBuilder struct {
+	buf []byte
+}
+
+// fbFinish encodes t as the root table of a buffer
+func fbFinish(t fbTable) []byte {
+	b := &fbBuilder{buf: make([]byte, 4)}
+	root := t.write(b)
+	binary.LittleEndian.PutUint32(b.buf, uint32(root))
+	return b.buf
+}
+
+// pad appends zeros until the length is offset past a multiple of n
+func (b *fbBuilder) pad(n, offset int) {
+	for len(b.buf)%n != offset {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// reference fills in the offset at pos to the object at target
+func (b *fbBuilder) reference(pos, target int) {
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(target-pos))
+}
+
+// write encodes the table and the objects it refers to and returns the
+// table's position
+func (t fbTable) write(b *fbBuilder) int {
+	// Lay out the fields largest first so each is aligned to its size, given
+	// that the table starts 4 bytes before an 8 byte boundary; references
+	// are 4 byte offsets
+	fields := append(fbTable(nil), t...)
+	size := func(f fbField) int {
+		if f.child != nil {
+			return 4
+		}
+		return len(f.scalar)
+	}
+	sort.SliceStable(fields, func(i, j int) bool { return size(fields[i]) > size(fields[j]) })
+
+	numSlots := 0
+	for _, f := range fields {
+		numSlots = max(numSlots, f.slot+1)
+	}
+
+	offsets := make([]int, len(fields))
+	tableSize := 4
+	for i, f := range fields {
+		offsets[i] = tableSize
+		tableSize += size(f)
+	}
+	tableSize += (4 - tableSize%4) % 4
+
+	// The vtable: its size, the table size and each slot's field offset
+	b.pad(2, 0)
+	vtable := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(4+2*numSlots))
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(tableSize))
+	slots := make([]byte, 2*numSlots)
+	for i, f := range fields {
+		binary.LittleEndian.PutUint16(slots[2*f.slot:], uint16(offsets[i]))
+	}
+	b.buf = append(b.buf, slots...)
+
+	b.pad(8, 4)
+	table := len(b.buf)
+	b.buf = append(b.buf, make([]byte, tableSize)...)
+	binary.LittleEndian.PutUint32(b.buf[table:], uint32(int32(table-vtable)))
+
+	for i, f := range fields {
+		if f.child == nil {
+			copy(b.buf[table+offsets[i]:], f.scalar)
+		}
+	}
+	for i, f := range fields {
+		if f.child != nil {
+			b.reference(table+offsets[i], f.child(b))
+		}
+	}
+	return table
+}
+
+// fbString returns a child writing a string
+func fbString(s string) func(b *fbBuilder) int {
+	return func(b *fbBuilder) int {
+		b.pad(4, 0)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(s)))
+		b.buf = append(b.buf, s...)
+		b.buf = append(b.buf, 0)
+		return pos
+	}
+}
+
+// fbBytes returns a child writing a vector of bytes
+func fbBytes(data []byte) func(b *fbBuilder) int {
+	return func(b *fbBuilder) int {
+		b.pad(4, 0)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(data)))
+		b.buf = append(b.buf, data...)
+		return pos
+	}
+}
+
+// fbDoubles returns a child writing a vector of doubles
+func fbDoubles(values []float64) func(b *fbBuilder) int {
+	return func(b *fbBuilder) int {
+		// The elements follow the length and must be 8 byte aligned
+		b.pad(8, 4)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(values)))
+		for _, v := range values {
+			b.buf = binary.LittleEndian.AppendUint64(b.buf, math.Float64bits(v))
+		}
+		return pos
+	}
+}
+
+// fbTables returns a child writing a vector of tables
+func fbTables(tables []fbTable) func(b *fbBuilder) int {
+	return func(b *fbBuilder) int {
+		b.pad(4, 0)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(tables)))
+		b.buf = append(b.buf, make([]byte, 4*len(tables))...)
+		for i, t := range tables {
+			b.reference(pos+4+4*i, t.write(b))
+		}
+		return pos
+	}
+}
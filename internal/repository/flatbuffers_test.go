@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// fbReader reads tables from an encoded FlatBuffer, failing the test on
+// offsets that point outside it
+type fbReader struct {
+	t   *testing.T
+	buf []byte
+}
+
+func (r fbReader) u16(pos int) int {
+	r.t.Helper()
+	if pos < 0 || pos+2 > len(r.buf) {
+		r.t.Fatalf("offset %d outside buffer of %d bytes", pos, len(r.buf))
+	}
+	return int(binary.LittleEndian.Uint16(r.buf[pos:]))
+}
+
+func (r fbReader) u32(pos int) int {
+	r.t.Helper()
+	if pos < 0 || pos+4 > len(r.buf) {
+		r.t.Fatalf("offset %d outside buffer of %d bytes", pos, len(r.buf))
+	}
+	return int(binary.LittleEndian.Uint32(r.buf[pos:]))
+}
+
+// root returns the position of the root table
+func (r fbReader) root() int {
+	return r.u32(0)
+}
+
+// field returns the position of a table's field, or 0 when it is absent
+func (r fbReader) field(table, slot int) int {
+	r.t.Helper()
+	vtable := table - int(int32(r.u32(table)))
+	if 4+2*slot >= r.u16(vtable) {
+		return 0
+	}
+	if offset := r.u16(vtable + 4 + 2*slot); offset != 0 {
+		return table + offset
+	}
+	return 0
+}
+
+// ref follows the offset stored in a field or vector element
+func (r fbReader) ref(pos int) int {
+	return pos + r.u32(pos)
+}
+
+func (r fbReader) string(table, slot int) string {
+	r.t.Helper()
+	pos := r.field(table, slot)
+	if pos == 0 {
+		return ""
+	}
+	s := r.ref(pos)
+	return string(r.buf[s+4 : s+4+r.u32(s)])
+}
+
+// vector returns the position of the first element and the length of a
+// vector field
+func (r fbReader) vector(table, slot int) (int, int) {
+	r.t.Helper()
+	pos := r.field(table, slot)
+	if pos == 0 {
+		return 0, 0
+	}
+	v := r.ref(pos)
+	return v + 4, r.u32(v)
+}
+
+func (r fbReader) doubles(table, slot int) []float64 {
+	r.t.Helper()
+	start, n := r.vector(table, slot)
+	if start%8 != 0 {
+		r.t.Errorf("doubles of slot %d start at %d, not 8 byte aligned", slot, start)
+	}
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = math.Float64frombits(binary.LittleEndian.Uint64(r.buf[start+8*i:]))
+	}
+	return values
+}
+
+func TestFBFinish(t *testing.T) {
+	got := fbFinish(fbTable{
+		{slot: 0, scalar: []byte{7}},
+		{slot: 1, child: fbString("ab")},
+	})
+	want := []byte{
+		0x0c, 0x00, 0x00, 0x00, // root table at 12
+		0x08, 0x00, 0x0c, 0x00, // vtable: 8 bytes, table of 12 bytes
+		0x08, 0x00, 0x04, 0x00, // slot 0 at 8, slot 1 at 4
+		0x08, 0x00, 0x00, 0x00, // table: vtable 8 bytes back
+		0x08, 0x00, 0x00, 0x00, // slot 1: string 8 bytes on
+		0x07, 0x00, 0x00, 0x00, // slot 0 and padding
+		0x02, 0x00, 0x00, 0x00, 'a', 'b', 0x00,
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("fbFinish =\n% x\nwant\n% x", got, want)
+	}
+}
+
+func TestFBTableLayout(t *testing.T) {
+	child := fbTable{{slot: 0, child: fbString("child")}}
+	buf := fbFinish(fbTable{
+		{slot: 0, scalar: []byte{1}},
+		{slot: 1, scalar: binary.LittleEndian.AppendUint16(nil, 2)},
+		{slot: 2, scalar: binary.LittleEndian.AppendUint64(nil, 3)},
+		{slot: 3, child: fbDoubles([]float64{1.5, -2.5})},
+		{slot: 4, child: fbBytes([]byte{9, 8, 7})},
+		{slot: 5, child: fbTables([]fbTable{child, child})},
+		{slot: 7, scalar: binary.LittleEndian.AppendUint32(nil, 4)},
+	})
+	r := fbReader{t, buf}
+	root := r.root()
+
+	if root%8 != 4 {
+		t.Errorf("root table at %d, want 4 past an 8 byte boundary", root)
+	}
+	if got := buf[r.field(root, 0)]; got != 1 {
+		t.Errorf("slot 0 = %d, want 1", got)
+	}
+	if pos := r.field(root, 1); pos%2 != 0 || r.u16(pos) != 2 {
+		t.Errorf("slot 1 at %d = %d, want 2 aligned", pos, r.u16(pos))
+	}
+	if pos := r.field(root, 2); pos%8 != 0 || binary.LittleEndian.Uint64(buf[pos:]) != 3 {
+		t.Errorf("slot 2 at %d = %d, want 3 aligned", pos, binary.LittleEndian.Uint64(buf[pos:]))
+	}
+	if got := r.doubles(root, 3); len(got) != 2 || got[0] != 1.5 || got[1] != -2.5 {
+		t.Errorf("slot 3 = %v, want [1.5 -2.5]", got)
+	}
+	if start, n := r.vector(root, 4); !bytes.Equal(buf[start:start+n], []byte{9, 8, 7}) {
+		t.Errorf("slot 4 = %v, want [9 8 7]", buf[start:start+n])
+	}
+	start, n := r.vector(root, 5)
+	if n != 2 {
+		t.Fatalf("slot 5 has %d tables, want 2", n)
+	}
+	for i := 0; i < n; i++ {
+		if got := r.string(r.ref(start+4*i), 0); got != "child" {
+			t.Errorf("slot 5 table %d name = %q, want child", i, got)
+		}
+	}
+	if pos := r.field(root, 6); pos != 0 {
+		t.Errorf("unset slot 6 at %d, want absent", pos)
+	}
+	if pos := r.field(root, 7); pos%4 != 0 || r.u32(pos) != 4 {
+		t.Errorf("slot 7 at %d = %d, want 4 aligned", pos, r.u32(pos))
+	}
+}
@@ -0,0 +1,417 @@
+package repository
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+// ExportFlatGeobuf is the export format written by NewFlatGeobufWriter
+const ExportFlatGeobuf = "fgb"
+
+// flatGeobufMagic starts every FlatGeobuf file: "fgb", spec version 3,
+// "fgb" and patch version 0
+var flatGeobufMagic = []byte{0x66, 0x67, 0x62, 0x03, 0x66, 0x67, 0x62, 0x00}
+
+// flatGeobufNodeSize is the number of children of each R-tree node
+const flatGeobufNodeSize = 16
+
+// FlatGeobuf geometry and column types used by the schools layer
+const (
+	fgbGeometryPoint = 1
+
+	fgbColumnLong     = 7
+	fgbColumnDouble   = 10
+	fgbColumnString   = 11
+	fgbColumnDateTime = 13
+)
+
+// fgbItem is a written feature: its bounds, where it was written in the
+// temporary feature file and its position on the Hilbert curve
+type fgbItem struct {
+	x, y    float64
+	offset  int64
+	size    int64
+	hilbert uint32
+}
+
+// fgbNode is a node of the packed R-tree. Leaves point to a feature's byte
+// offset in the features section, other nodes to their first child node.
+type fgbNode struct {
+	minX, minY, maxX, maxY float64
+	offset                 uint64
+}
+
+// flatGeobufWriter writes schools as a FlatGeobuf point layer. Features are
+// written to a temporary file as they arrive; Close sorts them along a
+// Hilbert curve and writes the header, the packed R-tree and the features.
+type flatGeobufWriter struct {
+	path string
+	temp *os.File
+	w    *bufio.Writer
+
+	items  []fgbItem
+	size   int64
+	extent BoundingBox
+}
+
+// NewFlatGeobufWriter creates a FlatGeobuf file at path, which must not
+// exist, with a schools point layer holding every models.SchoolFields
+// attribute and a packed Hilbert R-tree so clients can read the features
+// in a bounding box with HTTP range requests.
+func NewFlatGeobufWriter(path string) (SchoolWriter, error) {
+	if err := ensureNewFile("FlatGeobuf", path); err != nil {
+		return nil, err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), ".schools-*.fgb")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create FlatGeobuf: %w", err)
+	}
+
+	return &flatGeobufWriter{
+		path:   path,
+		temp:   temp,
+		w:      bufio.NewWriter(temp),
+		extent: emptyExtent(),
+	}, nil
+}
+
+func (fw *flatGeobufWriter) WriteSchool(school *models.School) error {
+	feature := flatGeobufFeature(school)
+
+	var prefix [4]byte
+	binary.LittleEndian.PutUint32(prefix[:], uint32(len(feature)))
+	if _, err := fw.w.Write(prefix[:]); err != nil {
+		return err
+	}
+	if _, err := fw.w.Write(feature); err != nil {
+		return err
+	}
+
+	size := int64(len(prefix) + len(feature))
+	fw.items = append(fw.items, fgbItem{x: school.Longitude, y: school.Latitude, offset: fw.size, size: size})
+	fw.size += size
+
+	fw.extent.extend(school.Longitude, school.Latitude)
+	return nil
+}
+
+// Close sorts the features and writes the FlatGeobuf file
+func (fw *flatGeobufWriter) Close() error {
+	defer os.Remove(fw.temp.Name())
+	defer fw.temp.Close()
+
+	if err := fw.w.Flush(); err != nil {
+		return fmt.Errorf("failed to finish FlatGeobuf: %w", err)
+	}
+	if err := fw.finish(); err != nil {
+		os.Remove(fw.path)
+		return fmt.Errorf("failed to finish FlatGeobuf: %w", err)
+	}
+	return nil
+}
+
+// abort removes the temporary feature file without writing the output
+func (fw *flatGeobufWriter) abort() {
+	fw.temp.Close()
+	os.Remove(fw.temp.Name())
+}
+
+// finish writes the output file from the temporary feature file
+func (fw *flatGeobufWriter) finish() error {
+	fw.hilbertSort()
+
+	file, err := os.Create(fw.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	if _, err := w.Write(flatGeobufMagic); err != nil {
+		return err
+	}
+
+	header := flatGeobufHeader(len(fw.items), fw.extent)
+	var prefix [4]byte
+	binary.LittleEndian.PutUint32(prefix[:], uint32(len(header)))
+	if _, err := w.Write(prefix[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	var node [40]byte
+	for _, n := range fw.packedRTree() {
+		binary.LittleEndian.PutUint64(node[0:], math.Float64bits(n.minX))
+		binary.LittleEndian.PutUint64(node[8:], math.Float64bits(n.minY))
+		binary.LittleEndian.PutUint64(node[16:], math.Float64bits(n.maxX))
+		binary.LittleEndian.PutUint64(node[24:], math.Float64bits(n.maxY))
+		binary.LittleEndian.PutUint64(node[32:], n.offset)
+		if _, err := w.Write(node[:]); err != nil {
+			return err
+		}
+	}
+
+	// Copy the features in index order
+	for _, item := range fw.items {
+		if _, err := io.Copy(w, io.NewSectionReader(fw.temp, item.offset, item.size)); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// hilbertSort orders the items by the Hilbert value of their position
+// within the layer extent, as the packed R-tree expects
+func (fw *flatGeobufWriter) hilbertSort() {
+	const hilbertMax = 1<<16 - 1
+
+	width := fw.extent.MaxLon - fw.extent.MinLon
+	height := fw.extent.MaxLat - fw.extent.MinLat
+	for i := range fw.items {
+		var x, y uint32
+		if width > 0 {
+			x = uint32(math.Floor(hilbertMax * (fw.items[i].x - fw.extent.MinLon) / width))
+		}
+		if height > 0 {
+			y = uint32(math.Floor(hilbertMax * (fw.items[i].y - fw.extent.MinLat) / height))
+		}
+		fw.items[i].hilbert = hilbert(x, y)
+	}
+
+	sort.SliceStable(fw.items, func(i, j int) bool {
+		return fw.items[i].hilbert > fw.items[j].hilbert
+	})
+}
+
+// packedRTree builds the packed R-tree over the sorted items. Nodes are
+// stored root first, level by level, with the leaves last.
+func (fw *flatGeobufWriter) packedRTree() []fgbNode {
+	if len(fw.items) == 0 {
+		return nil
+	}
+
+	levels := packedRTreeLevels(len(fw.items), flatGeobufNodeSize)
+	nodes := make([]fgbNode, levels[0][1])
+
+	var offset uint64
+	for i, item := range fw.items {
+		nodes[levels[0][0]+i] = fgbNode{minX: item.x, minY: item.y, maxX: item.x, maxY: item.y, offset: offset}
+		offset += uint64(item.size)
+	}
+
+	for level := 0; level < len(levels)-1; level++ {
+		pos, end := levels[level][0], levels[level][1]
+		parent := levels[level+1][0]
+		for pos < end {
+			node := fgbNode{
+				minX: math.Inf(1), minY: math.Inf(1), maxX: math.Inf(-1), maxY: math.Inf(-1),
+				offset: uint64(pos),
+			}
+			for j := 0; j < flatGeobufNodeSize && pos < end; j++ {
+				child := nodes[pos]
+				node.minX = math.Min(node.minX, child.minX)
+				node.minY = math.Min(node.minY, child.minY)
+				node.maxX = math.Max(node.maxX, child.maxX)
+				node.maxY = math.Max(node.maxY, child.maxY)
+				pos++
+			}
+			nodes[parent] = node
+			parent++
+		}
+	}
+	return nodes
+}
+
+// packedRTreeLevels returns the [start, end) node range of each level of a
+// packed R-tree, leaves first. The leaves are stored last, so their end is
+// the total number of nodes, and the root is node 0.
+func packedRTreeLevels(numItems, nodeSize int) [][2]int {
+	n := numItems
+	numNodes := n
+	counts := []int{n}
+	for {
+		n = (n + nodeSize - 1) / nodeSize
+		numNodes += n
+		counts = append(counts, n)
+		if n == 1 {
+			break
+		}
+	}
+
+	levels := make([][2]int, len(counts))
+	end := numNodes
+	for i, count := range counts {
+		levels[i] = [2]int{end - count, end}
+		end -= count
+	}
+	return levels
+}
+
+// hilbert returns the position of x, y on a 2^16 by 2^16 Hilbert curve
+func hilbert(x, y uint32) uint32 {
+	a := x ^ y
+	b := 0xFFFF ^ a
+	c := 0xFFFF ^ (x | y)
+	d := x & (y ^ 0xFFFF)
+
+	A := a | (b >> 1)
+	B := (a >> 1) ^ a
+	C := ((c >> 1) ^ (b & (d >> 1))) ^ c
+	D := ((a & (c >> 1)) ^ (d >> 1)) ^ d
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 2)) ^ (b & (b >> 2))
+	B = (a & (b >> 2)) ^ (b & ((a ^ b) >> 2))
+	C ^= (a & (c >> 2)) ^ (b & (d >> 2))
+	D ^= (b & (c >> 2)) ^ ((a ^ b) & (d >> 2))
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 4)) ^ (b & (b >> 4))
+	B = (a & (b >> 4)) ^ (b & ((a ^ b) >> 4))
+	C ^= (a & (c >> 4)) ^ (b & (d >> 4))
+	D ^= (b & (c >> 4)) ^ ((a ^ b) & (d >> 4))
+
+	a, b, c, d = A, B, C, D
+	C ^= (a & (c >> 8)) ^ (b & (d >> 8))
+	D ^= (b & (c >> 8)) ^ ((a ^ b) & (d >> 8))
+
+	a = C ^ (C >> 1)
+	b = D ^ (D >> 1)
+
+	i0 := x ^ y
+	i1 := b | (0xFFFF ^ (i0 | a))
+
+	i0 = (i0 | (i0 << 8)) & 0x00FF00FF
+	i0 = (i0 | (i0 << 4)) & 0x0F0F0F0F
+	i0 = (i0 | (i0 << 2)) & 0x33333333
+	i0 = (i0 | (i0 << 1)) & 0x55555555
+
+	i1 = (i1 | (i1 << 8)) & 0x00FF00FF
+	i1 = (i1 | (i1 << 4)) & 0x0F0F0F0F
+	i1 = (i1 | (i1 << 2)) & 0x33333333
+	i1 = (i1 | (i1 << 1)) & 0x55555555
+
+	return (i1 << 1) | i0
+}
+
+// flatGeobufColumnType returns the FlatGeobuf column type of a School field
+// and whether it may be null
+func flatGeobufColumnType(field interface{}) (byte, bool) {
+	switch field.(type) {
+	case *int64, *int:
+		return fgbColumnLong, false
+	case *sql.NullInt64:
+		return fgbColumnLong, true
+	case *float64:
+		return fgbColumnDouble, false
+	case *time.Time:
+		return fgbColumnDateTime, false
+	case *sql.NullTime:
+		return fgbColumnDateTime, true
+	case *string:
+		return fgbColumnString, false
+	}
+	return fgbColumnString, true
+}
+
+// flatGeobufHeader encodes the Header table describing the schools layer
+func flatGeobufHeader(count int, extent BoundingBox) []byte {
+	var school models.School
+	columns := make([]fbTable, len(models.SchoolFields))
+	for i, field := range models.SchoolFields {
+		columnType, nullable := flatGeobufColumnType(schoolFieldDest(&school, field))
+		columns[i] = fbTable{
+			{slot: 0, child: fbString(field)},
+			{slot: 1, scalar: []byte{columnType}},
+		}
+		if !nullable {
+			columns[i] = append(columns[i], fbField{slot: 7, scalar: []byte{0}})
+		}
+	}
+
+	crs := fbTable{
+		{slot: 0, child: fbString("EPSG")},
+		{slot: 1, scalar: binary.LittleEndian.AppendUint32(nil, 4326)},
+	}
+
+	nodeSize := uint16(flatGeobufNodeSize)
+	if count == 0 {
+		nodeSize = 0
+	}
+
+	header := fbTable{
+		{slot: 0, child: fbString(SchoolTileLayer)},
+		{slot: 2, scalar: []byte{fgbGeometryPoint}},
+		{slot: 7, child: fbTables(columns)},
+		{slot: 8, scalar: binary.LittleEndian.AppendUint64(nil, uint64(count))},
+		{slot: 9, scalar: binary.LittleEndian.AppendUint16(nil, nodeSize)},
+		{slot: 10, child: crs.write},
+		{slot: 11, child: fbString("Schools")},
+	}
+	if count > 0 {
+		header = append(header, fbField{
+			slot:  1,
+			child: fbDoubles([]float64{extent.MinLon, extent.MinLat, extent.MaxLon, extent.MaxLat}),
+		})
+	}
+	return fbFinish(header)
+}
+
+// flatGeobufFeature encodes the Feature table of a school: its point and
+// its non-null properties, each a column index followed by the value
+func flatGeobufFeature(school *models.School) []byte {
+	var props []byte
+	for i, field := range models.SchoolFields {
+		dest := schoolFieldDest(school, field)
+		var value []byte
+		switch v := dest.(type) {
+		case *int64:
+			value = binary.LittleEndian.AppendUint64(nil, uint64(*v))
+		case *int:
+			value = binary.LittleEndian.AppendUint64(nil, uint64(*v))
+		case *sql.NullInt64:
+			if v.Valid {
+				value = binary.LittleEndian.AppendUint64(nil, uint64(v.Int64))
+			}
+		case *float64:
+			value = binary.LittleEndian.AppendUint64(nil, math.Float64bits(*v))
+		default:
+			if text := formatField(dest); text != "" {
+				value = binary.LittleEndian.AppendUint32(nil, uint32(len(text)))
+				value = append(value, text...)
+			} else if _, nullable := flatGeobufColumnType(dest); !nullable {
+				value = binary.LittleEndian.AppendUint32(nil, 0)
+			}
+		}
+		if value == nil {
+			continue
+		}
+		props = binary.LittleEndian.AppendUint16(props, uint16(i))
+		props = append(props, value...)
+	}
+
+	geometry := fbTable{
+		{slot: 1, child: fbDoubles([]float64{school.Longitude, school.Latitude})},
+	}
+	return fbFinish(fbTable{
+		{slot: 0, child: geometry.write},
+		{slot: 1, child: fbBytes(props)},
+	})
+}
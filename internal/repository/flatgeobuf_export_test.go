@@ -0,0 +1,274 @@
+package repository
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+func TestFlatGeobufHeader(t *testing.T) {
+	extent := BoundingBox{MinLon: -120.5, MinLat: 30.25, MaxLon: -70, MaxLat: 45.75}
+	r := fbReader{t, flatGeobufHeader(3, extent)}
+	header := r.root()
+
+	if got := r.string(header, 0); got != SchoolTileLayer {
+		t.Errorf("name = %q, want %q", got, SchoolTileLayer)
+	}
+	if got := r.doubles(header, 1); !reflect.DeepEqual(got, []float64{-120.5, 30.25, -70, 45.75}) {
+		t.Errorf("envelope = %v", got)
+	}
+	if got := r.buf[r.field(header, 2)]; got != fgbGeometryPoint {
+		t.Errorf("geometry_type = %d, want %d", got, fgbGeometryPoint)
+	}
+	if got := binary.LittleEndian.Uint64(r.buf[r.field(header, 8):]); got != 3 {
+		t.Errorf("features_count = %d, want 3", got)
+	}
+	if got := r.u16(r.field(header, 9)); got != flatGeobufNodeSize {
+		t.Errorf("index_node_size = %d, want %d", got, flatGeobufNodeSize)
+	}
+	crs := r.ref(r.field(header, 10))
+	if org, code := r.string(crs, 0), r.u32(r.field(crs, 1)); org != "EPSG" || code != 4326 {
+		t.Errorf("crs = %s:%d, want EPSG:4326", org, code)
+	}
+	if got := r.string(header, 11); got != "Schools" {
+		t.Errorf("title = %q, want Schools", got)
+	}
+
+	start, n := r.vector(header, 7)
+	if n != len(models.SchoolFields) {
+		t.Fatalf("%d columns, want %d", n, len(models.SchoolFields))
+	}
+	want := map[string]struct {
+		columnType byte
+		nullable   bool
+	}{
+		"id":         {fgbColumnLong, false},
+		"name":       {fgbColumnString, false},
+		"city":       {fgbColumnString, true},
+		"latitude":   {fgbColumnDouble, false},
+		"enrollment": {fgbColumnLong, true},
+		"sourcedate": {fgbColumnDateTime, true},
+		"created_at": {fgbColumnDateTime, false},
+	}
+	for i := 0; i < n; i++ {
+		column := r.ref(start + 4*i)
+		name := r.string(column, 0)
+		if name != models.SchoolFields[i] {
+			t.Errorf("column %d = %q, want %q", i, name, models.SchoolFields[i])
+		}
+		w, ok := want[name]
+		if !ok {
+			continue
+		}
+		nullable := true
+		if pos := r.field(column, 7); pos != 0 {
+			nullable = r.buf[pos] != 0
+		}
+		if got := r.buf[r.field(column, 1)]; got != w.columnType || nullable != w.nullable {
+			t.Errorf("column %s = type %d nullable %v, want type %d nullable %v", name, got, nullable, w.columnType, w.nullable)
+		}
+	}
+}
+
+func TestFlatGeobufHeaderEmpty(t *testing.T) {
+	r := fbReader{t, flatGeobufHeader(0, BoundingBox{})}
+	header := r.root()
+
+	if pos := r.field(header, 1); pos != 0 {
+		t.Error("empty layer has an envelope")
+	}
+	if got := r.u16(r.field(header, 9)); got != 0 {
+		t.Errorf("index_node_size = %d, want 0 without an index", got)
+	}
+}
+
+// flatGeobufProperties decodes the properties of a feature by column name
+func flatGeobufProperties(t *testing.T, r fbReader, feature int) map[string]interface{} {
+	t.Helper()
+	var school models.School
+	start, n := r.vector(feature, 1)
+	props := r.buf[start : start+n]
+
+	values := make(map[string]interface{})
+	for len(props) > 0 {
+		i := int(binary.LittleEndian.Uint16(props))
+		props = props[2:]
+		name := models.SchoolFields[i]
+		switch columnType, _ := flatGeobufColumnType(schoolFieldDest(&school, name)); columnType {
+		case fgbColumnLong:
+			values[name] = int64(binary.LittleEndian.Uint64(props))
+			props = props[8:]
+		case fgbColumnDouble:
+			values[name] = math.Float64frombits(binary.LittleEndian.Uint64(props))
+			props = props[8:]
+		default:
+			size := int(binary.LittleEndian.Uint32(props))
+			values[name] = string(props[4 : 4+size])
+			props = props[4+size:]
+		}
+	}
+	return values
+}
+
+func TestFlatGeobufFeature(t *testing.T) {
+	school := &models.School{
+		ID:         7,
+		ObjectID:   42,
+		Name:       "Lincoln Elementary",
+		City:       sql.NullString{String: "Springfield", Valid: true},
+		Latitude:   39.78,
+		Longitude:  -89.65,
+		Enrollment: sql.NullInt64{Int64: 300, Valid: true},
+	}
+	r := fbReader{t, flatGeobufFeature(school)}
+	feature := r.root()
+
+	geometry := r.ref(r.field(feature, 0))
+	if got := r.doubles(geometry, 1); !reflect.DeepEqual(got, []float64{-89.65, 39.78}) {
+		t.Errorf("xy = %v, want [-89.65 39.78]", got)
+	}
+
+	props := flatGeobufProperties(t, r, feature)
+	want := map[string]interface{}{
+		"id":         int64(7),
+		"objectid":   int64(42),
+		"name":       "Lincoln Elementary",
+		"city":       "Springfield",
+		"latitude":   39.78,
+		"enrollment": int64(300),
+	}
+	for name, value := range want {
+		if props[name] != value {
+			t.Errorf("%s = %v, want %v", name, props[name], value)
+		}
+	}
+	for _, name := range []string{"state", "ft_teacher", "sourcedate"} {
+		if value, ok := props[name]; ok {
+			t.Errorf("null %s written as %v", name, value)
+		}
+	}
+	if _, ok := props["created_at"]; !ok {
+		t.Error("non-nullable created_at left out")
+	}
+}
+
+func TestPackedRTreeLevels(t *testing.T) {
+	tests := []struct {
+		numItems int
+		want     [][2]int
+	}{
+		{1, [][2]int{{1, 2}, {0, 1}}},
+		{16, [][2]int{{1, 17}, {0, 1}}},
+		{17, [][2]int{{3, 20}, {1, 3}, {0, 1}}},
+		{300, [][2]int{{22, 322}, {3, 22}, {1, 3}, {0, 1}}},
+	}
+	for _, tt := range tests {
+		if got := packedRTreeLevels(tt.numItems, 16); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("packedRTreeLevels(%d, 16) = %v, want %v", tt.numItems, got, tt.want)
+		}
+	}
+}
+
+func TestFlatGeobufWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schools.fgb")
+	w, err := NewFlatGeobufWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Enough schools for a four level index
+	const numSchools = 300
+	for i := 0; i < numSchools; i++ {
+		err := w.WriteSchool(&models.School{
+			ID:        int64(i + 1),
+			Name:      fmt.Sprintf("School %d", i+1),
+			Longitude: -120 + float64(i%20)*2,
+			Latitude:  30 + float64(i/20),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:8], flatGeobufMagic) {
+		t.Fatalf("magic = % x", data[:8])
+	}
+	headerSize := int(binary.LittleEndian.Uint32(data[8:]))
+	header := fbReader{t, data[12 : 12+headerSize]}
+	envelope := header.doubles(header.root(), 1)
+	if !reflect.DeepEqual(envelope, []float64{-120, 30, -82, 44}) {
+		t.Errorf("envelope = %v", envelope)
+	}
+
+	levels := packedRTreeLevels(numSchools, flatGeobufNodeSize)
+	index := data[12+headerSize:]
+	node := func(i int) fgbNode {
+		b := index[40*i:]
+		return fgbNode{
+			minX:   math.Float64frombits(binary.LittleEndian.Uint64(b[0:])),
+			minY:   math.Float64frombits(binary.LittleEndian.Uint64(b[8:])),
+			maxX:   math.Float64frombits(binary.LittleEndian.Uint64(b[16:])),
+			maxY:   math.Float64frombits(binary.LittleEndian.Uint64(b[24:])),
+			offset: binary.LittleEndian.Uint64(b[32:]),
+		}
+	}
+	features := index[40*levels[0][1]:]
+
+	if root := node(0); !reflect.DeepEqual([]float64{root.minX, root.minY, root.maxX, root.maxY}, envelope) {
+		t.Errorf("root bounds %v, want the envelope", root)
+	}
+
+	// Each parent starts at its first child and bounds its children
+	for level := 1; level < len(levels); level++ {
+		children := levels[level-1]
+		for p := levels[level][0]; p < levels[level][1]; p++ {
+			parent := node(p)
+			first := int(parent.offset)
+			if want := children[0] + (p-levels[level][0])*flatGeobufNodeSize; first != want {
+				t.Errorf("node %d points at %d, want %d", p, first, want)
+			}
+			for c := first; c < min(first+flatGeobufNodeSize, children[1]); c++ {
+				child := node(c)
+				if child.minX < parent.minX || child.minY < parent.minY || child.maxX > parent.maxX || child.maxY > parent.maxY {
+					t.Errorf("node %d %v outside parent %d %v", c, child, p, parent)
+				}
+			}
+		}
+	}
+
+	// Each leaf points at the feature with its point, and every school is
+	// written once
+	seen := make(map[string]bool)
+	for i := levels[0][0]; i < levels[0][1]; i++ {
+		leaf := node(i)
+		size := int(binary.LittleEndian.Uint32(features[leaf.offset:]))
+		r := fbReader{t, features[leaf.offset+4 : int(leaf.offset)+4+size]}
+		xy := r.doubles(r.ref(r.field(r.root(), 0)), 1)
+		if leaf.minX != leaf.maxX || leaf.minY != leaf.maxY || xy[0] != leaf.minX || xy[1] != leaf.minY {
+			t.Errorf("leaf %d bounds %v, feature at %v", i, leaf, xy)
+		}
+		name := flatGeobufProperties(t, r, r.root())["name"].(string)
+		if seen[name] {
+			t.Errorf("%s written twice", name)
+		}
+		seen[name] = true
+	}
+	if len(seen) != numSchools {
+		t.Errorf("%d schools in the index, want %d", len(seen), numSchools)
+	}
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+// NewFeatureCollection wraps schools in an RFC 7946 FeatureCollection
+func NewFeatureCollection(schools []*models.School) GeoJSONFeatureCollection {
+	collection := GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]GeoJSONFeature, len(schools)),
+	}
+	for i, school := range schools {
+		collection.Features[i] = FeatureFromSchool(school)
+	}
+	return collection
+}
+
+// FeatureFromSchool converts a School model to a GeoJSON Point feature.
+// It is the inverse of ExtractSchoolFromFeature: property names match the
+// source data and null attributes are omitted.
+func FeatureFromSchool(school *models.School) GeoJSONFeature {
+	props := map[string]interface{}{
+		"id":         school.ID,
+		"objectid":   school.ObjectID,
+		"name":       school.Name,
+		"created_at": school.CreatedAt.Format(time.RFC3339),
+		"updated_at": school.UpdatedAt.Format(time.RFC3339),
+	}
+
+	if school.Address.Valid {
+		props["address"] = school.Address.String
+	}
+	if school.City.Valid {
+		props["city"] = school.City.String
+	}
+	if school.State.Valid {
+		props["state"] = school.State.String
+	}
+	if school.Zip.Valid {
+		props["zip"] = school.Zip.String
+	}
+	if school.Country.Valid {
+		props["country"] = school.Country.String
+	}
+	if school.County.Valid {
+		props["county"] = school.County.String
+	}
+	if school.CountyFIPS.Valid {
+		props["countyfips"] = school.CountyFIPS.String
+	}
+	if school.Level.Valid {
+		props["level"] = school.Level.String
+	}
+	if school.StartGrade.Valid {
+		props["st_grade"] = school.StartGrade.String
+	}
+	if school.EndGrade.Valid {
+		props["end_grade"] = school.EndGrade.String
+	}
+	if school.Enrollment.Valid {
+		props["enrollment"] = school.Enrollment.Int64
+	}
+	if school.FTTeacher.Valid {
+		props["ft_teacher"] = school.FTTeacher.Int64
+	}
+	if school.Type.Valid {
+		props["type"] = school.Type.Int64
+	}
+	if school.Status.Valid {
+		props["status"] = school.Status.Int64
+	}
+	if school.Population.Valid {
+		props["population"] = school.Population.Int64
+	}
+	if school.NCESID.Valid {
+		props["ncesid"] = school.NCESID.String
+	}
+	if school.DistrictID.Valid {
+		props["districtid"] = school.DistrictID.String
+	}
+	if school.NAICSCode.Valid {
+		props["naics_code"] = school.NAICSCode.String
+	}
+	if school.NAICSDesc.Valid {
+		props["naics_desc"] = school.NAICSDesc.String
+	}
+	if school.Website.Valid {
+		props["website"] = school.Website.String
+	}
+	if school.Telephone.Valid {
+		props["telephone"] = school.Telephone.String
+	}
+	if school.SourceDate.Valid {
+		props["sourcedate"] = school.SourceDate.Time.Format(time.RFC3339)
+	}
+	if school.ValDate.Valid {
+		props["val_date"] = school.ValDate.Time.Format(time.RFC3339)
+	}
+	if school.ValMethod.Valid {
+		props["val_method"] = school.ValMethod.String
+	}
+	if school.Source.Valid {
+		props["source"] = school.Source.String
+	}
+	if school.ShelterID.Valid {
+		props["shelter_id"] = school.ShelterID.String
+	}
+
+	return GeoJSONFeature{
+		Type:       "Feature",
+		ID:         school.ID,
+		Properties: props,
+		Geometry: GeoJSONGeometry{
+			Type:        "Point",
+			Coordinates: []float64{school.Longitude, school.Latitude},
+		},
+	}
+}
@@ -1,8 +1,11 @@
 package repository
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"time"
@@ -18,6 +21,7 @@ type GeoJSONFeatureCollection struct {
 
 type GeoJSONFeature struct {
 	Type       string                 `json:"type"`
+	ID         interface{}            `json:"id,omitempty"`
 	Properties map[string]interface{} `json:"properties"`
 	Geometry   GeoJSONGeometry        `json:"geometry"`
 }
@@ -27,101 +31,144 @@ type GeoJSONGeometry struct {
 	Coordinates []float64 `json:"coordinates"`
 }
 
+// ErrInvalidGeoJSON is returned when the input is not a FeatureCollection
+var ErrInvalidGeoJSON = errors.New("invalid GeoJSON")
+
 // ImportFromGeoJSON imports schools from a GeoJSON file
-func (r *SchoolRepository) ImportFromGeoJSON(filePath string) (int, error) {
+func (r *SchoolRepository) ImportFromGeoJSON(filePath string, opts ImportOptions) (models.ImportStats, error) {
 	// Open the GeoJSON file
 	file, err := os.Open(filePath)
 	if err != nil {
-		return 0, fmt.Errorf("error opening file: %w", err)
+		return models.ImportStats{}, fmt.Errorf("error opening file: %w", err)
 	}
 	defer file.Close()
 
-	// Decode the JSON
-	var featureCollection GeoJSONFeatureCollection
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&featureCollection); err != nil {
-		return 0, fmt.Errorf("error decoding JSON: %w", err)
-	}
+	return r.ImportGeoJSONStream(bufio.NewReader(file), opts)
+}
 
-	// Begin transaction
-	tx, err := r.DB.Begin()
+// ImportGeoJSONStream imports schools from a GeoJSON FeatureCollection read
+// from rd. Features are decoded one at a time and written in batches, so
+// memory use does not grow with the input size. On error the schools from
+// already committed batches remain imported and the counts so far are
+// returned with the error.
+func (r *SchoolRepository) ImportGeoJSONStream(rd io.Reader, opts ImportOptions) (models.ImportStats, error) {
+	importer, err := r.newSchoolImporter(opts)
 	if err != nil {
-		return 0, fmt.Errorf("error beginning transaction: %w", err)
+		return models.ImportStats{}, err
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
-
-	// Prepare the insert statement
-	stmt, err := tx.Prepare(`
-	INSERT INTO schools (
-		objectid, name, address, city, state, zip, country, county, countyfips,
-		latitude, longitude, level, st_grade, end_grade, enrollment, ft_teacher,
-		type, status, population, ncesid, districtid, naics_code, naics_desc,
-		website, telephone, sourcedate, val_date, val_method, source, shelter_id
-	) VALUES (
-		$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16,
-		$17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30
-	) ON CONFLICT (objectid) DO NOTHING
-	`)
-	if err != nil {
-		return 0, fmt.Errorf("error preparing statement: %w", err)
-	}
-	defer stmt.Close()
 
-	// Prepare the geometry update statement
-	geoStmt, err := tx.Prepare(`
-	UPDATE schools SET location = ST_SetSRID(ST_MakePoint($1, $2), 4326) 
-	WHERE objectid = $3
-	`)
-	if err != nil {
-		return 0, fmt.Errorf("error preparing geometry statement: %w", err)
-	}
-	defer geoStmt.Close()
+	err = StreamGeoJSONFeatures(rd, func(feature GeoJSONFeature) error {
+		index := importer.stats.Processed
+		importer.stats.Processed++
 
-	// Insert each feature as a school
-	count := 0
-	for _, feature := range featureCollection.Features {
 		// Skip if not a Point geometry
 		if feature.Geometry.Type != "Point" {
-			continue
+			importer.stats.SkippedNonPoint++
+			importer.stats.AddIssue(index, 0, fmt.Sprintf("geometry type %q is not Point", feature.Geometry.Type))
+			return nil
 		}
 
 		// Extract properties
 		school := r.ExtractSchoolFromFeature(feature)
+		if err := checkFeatureDates(feature, school); err != nil {
+			importer.reject(index, &school, err)
+			return nil
+		}
+		return importer.add(index, school)
+	})
+	if err == nil {
+		err = importer.finish()
+	}
+
+	return importer.stats, err
+}
 
-		fmt.Println("objectid:", school.ObjectID)
-
-		// Execute the insert
-		_, err = stmt.Exec(
-			school.ObjectID, school.Name, school.Address, school.City, school.State,
-			school.Zip, school.Country, school.County, school.CountyFIPS, school.Latitude,
-			school.Longitude, school.Level, school.StartGrade, school.EndGrade, school.Enrollment,
-			school.FTTeacher, school.Type, school.Status, school.Population, school.NCESID,
-			school.DistrictID, school.NAICSCode, school.NAICSDesc, school.Website, school.Telephone,
-			school.SourceDate, school.ValDate, school.ValMethod, school.Source, school.ShelterID,
-		)
+// StreamGeoJSONFeatures decodes a GeoJSON FeatureCollection from rd and calls
+// fn for each feature as it is read. Members other than "features" are
+// skipped. Decoding stops at the first error returned by fn.
+func StreamGeoJSONFeatures(rd io.Reader, fn func(GeoJSONFeature) error) error {
+	decoder := json.NewDecoder(rd)
+
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
 		if err != nil {
-			return 0, fmt.Errorf("error inserting school: %w", err)
+			return fmt.Errorf("error decoding JSON: %w", err)
 		}
 
-		// Update the geometry
-		_, err = geoStmt.Exec(school.Longitude, school.Latitude, school.ObjectID)
-		if err != nil {
-			return 0, fmt.Errorf("error updating geometry: %w", err)
+		if key, _ := token.(string); key != "features" {
+			var skip json.RawMessage
+			if err := decoder.Decode(&skip); err != nil {
+				return fmt.Errorf("error decoding JSON: %w", err)
+			}
+			continue
 		}
 
-		count++
+		if err := expectDelim(decoder, '['); err != nil {
+			return err
+		}
+		for index := 0; decoder.More(); index++ {
+			var feature GeoJSONFeature
+			if err := decoder.Decode(&feature); err != nil {
+				return fmt.Errorf("error decoding feature %d: %w", index, err)
+			}
+			if err := fn(feature); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(decoder, ']'); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(decoder, '}')
+}
+
+// expectDelim reads the next token and checks that it is the given delimiter
+func expectDelim(decoder *json.Decoder, want json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("error decoding JSON: %w", err)
 	}
+	if delim, ok := token.(json.Delim); !ok || delim != want {
+		return fmt.Errorf("%w: expected %q, got %v", ErrInvalidGeoJSON, want, token)
+	}
+	return nil
+}
 
-	// Commit the transaction
-	if err = tx.Commit(); err != nil {
-		return 0, fmt.Errorf("error committing transaction: %w", err)
+// dateLayouts are the date formats accepted in source data
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006/01/02 15:04:05",
+	"2006-01-02",
+	"2006/01/02",
+}
+
+// parseDate parses a date in any of dateLayouts
+func parseDate(value string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
 	}
+	return time.Time{}, false
+}
 
-	return count, nil
+// checkFeatureDates reports date properties that ExtractSchoolFromFeature
+// could not parse and would otherwise silently drop
+func checkFeatureDates(feature GeoJSONFeature, school models.School) error {
+	if value, ok := feature.Properties["sourcedate"].(string); ok && value != "" && !school.SourceDate.Valid {
+		return fmt.Errorf("unparseable sourcedate %q", value)
+	}
+	if value, ok := feature.Properties["val_date"].(string); ok && value != "" && !school.ValDate.Valid {
+		return fmt.Errorf("unparseable val_date %q", value)
+	}
+	return nil
 }
 
 // ExtractSchoolFromFeature converts a GeoJSON feature to a School model
@@ -135,8 +182,6 @@ func (h *SchoolRepository) ExtractSchoolFromFeature(feature GeoJSONFeature) mode
 		school.Latitude = feature.Geometry.Coordinates[1]
 	}
 
-	fmt.Println("objectid:", props["objectid"])
-
 	// Extract properties
 	if objectIDStr, ok := props["objectid"].(string); ok {
 		// Convert string to int
@@ -258,14 +303,14 @@ func (h *SchoolRepository) ExtractSchoolFromFeature(feature GeoJSONFeature) mode
 	}
 
 	if sourcedate, ok := props["sourcedate"].(string); ok {
-		if t, err := time.Parse(time.RFC3339, sourcedate); err == nil {
+		if t, ok := parseDate(sourcedate); ok {
 			school.SourceDate.Time = t
 			school.SourceDate.Valid = true
 		}
 	}
 
 	if valDate, ok := props["val_date"].(string); ok {
-		if t, err := time.Parse(time.RFC3339, valDate); err == nil {
+		if t, ok := parseDate(valDate); ok {
 			school.ValDate.Time = t
 			school.ValDate.Valid = true
 		}
@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// geoJSONPoints returns a FeatureCollection of n points whose objectid is
+// their index, with the given members before and after the features
+func geoJSONPoints(n int, before, after string) string {
+	var b strings.Builder
+	b.WriteString("{" + before + `"features": [`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"type": "Feature", "properties": {"objectid": %d}, "geometry": {"type": "Point", "coordinates": [-90.5, 40.25]}}`, i)
+	}
+	b.WriteString("]" + after + "}")
+	return b.String()
+}
+
+// streamObjectIDs streams the features of input and returns their objectids
+func streamObjectIDs(rd io.Reader) ([]int, error) {
+	var ids []int
+	err := StreamGeoJSONFeatures(rd, func(feature GeoJSONFeature) error {
+		id, _ := feature.Properties["objectid"].(float64)
+		ids = append(ids, int(id))
+		return nil
+	})
+	return ids, err
+}
+
+func TestStreamGeoJSONFeatures(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []int
+	}{
+		{"features only", geoJSONPoints(2, "", ""), []int{0, 1}},
+		{
+			"features not first",
+			geoJSONPoints(2, `"type": "FeatureCollection", "crs": {"type": "name", "properties": {"name": "EPSG:4326"}}, `, ""),
+			[]int{0, 1},
+		},
+		{"members after features", geoJSONPoints(1, "", `, "name": "schools", "bbox": [1, 2, 3, 4]`), []int{0}},
+		{
+			"features inside other members are skipped",
+			geoJSONPoints(1, `"metadata": {"features": [{"properties": {"objectid": 9}}]}, `, ""),
+			[]int{0},
+		},
+		{"no features", geoJSONPoints(0, `"type": "FeatureCollection", `, ""), nil},
+		{"no features member", `{"type": "FeatureCollection"}`, nil},
+	}
+	for _, tt := range tests {
+		got, err := streamObjectIDs(strings.NewReader(tt.input))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: objectids %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStreamGeoJSONFeatureValues(t *testing.T) {
+	input := `{"type": "FeatureCollection", "features": [{"type": "Feature", "id": 3,
+		"properties": {"name": "Lincoln", "enrollment": 300},
+		"geometry": {"type": "Point", "coordinates": [-90.5, 40.25]}}]}`
+
+	var features []GeoJSONFeature
+	err := StreamGeoJSONFeatures(strings.NewReader(input), func(feature GeoJSONFeature) error {
+		features = append(features, feature)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GeoJSONFeature{
+		Type:       "Feature",
+		ID:         float64(3),
+		Properties: map[string]interface{}{"name": "Lincoln", "enrollment": float64(300)},
+		Geometry:   GeoJSONGeometry{Type: "Point", Coordinates: []float64{-90.5, 40.25}},
+	}
+	if len(features) != 1 || !reflect.DeepEqual(features[0], want) {
+		t.Errorf("features = %+v, want [%+v]", features, want)
+	}
+}
+
+func TestStreamGeoJSONFeaturesMalformed(t *testing.T) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	tests := []struct {
+		name  string
+		input string
+		// read is the objectids streamed before the error
+		read []int
+		is   func(error) bool
+	}{
+		{"array", `[]`, nil, func(err error) bool { return errors.Is(err, ErrInvalidGeoJSON) }},
+		{"empty", ``, nil, func(err error) bool { return errors.Is(err, io.EOF) }},
+		{"features not an array", `{"features": {}}`, nil, func(err error) bool { return errors.Is(err, ErrInvalidGeoJSON) }},
+		{
+			"feature not an object",
+			`{"features": [{"properties": {"objectid": 0}}, 5]}`,
+			[]int{0},
+			func(err error) bool { return errors.As(err, &typeErr) && strings.Contains(err.Error(), "feature 1") },
+		},
+		{
+			"coordinates not numbers",
+			`{"features": [{"properties": {"objectid": 0}}, {"geometry": {"coordinates": ["a", "b"]}}]}`,
+			[]int{0},
+			func(err error) bool { return errors.As(err, &typeErr) && strings.Contains(err.Error(), "feature 1") },
+		},
+		{
+			"syntax error in a feature",
+			`{"features": [{"properties": {"objectid": 0}}, {"properties": {objectid: 1}}]}`,
+			[]int{0},
+			func(err error) bool { return errors.As(err, &syntaxErr) },
+		},
+		{
+			"truncated feature",
+			`{"features": [{"properties": {"objectid": 0}}, {"properties": {"obj`,
+			[]int{0},
+			func(err error) bool { return errors.Is(err, io.ErrUnexpectedEOF) },
+		},
+		{
+			"unclosed collection",
+			geoJSONPoints(2, "", "")[:len(geoJSONPoints(2, "", ""))-1],
+			[]int{0, 1},
+			func(err error) bool { return errors.As(err, &syntaxErr) },
+		},
+	}
+	for _, tt := range tests {
+		got, err := streamObjectIDs(strings.NewReader(tt.input))
+		if err == nil || !tt.is(err) {
+			t.Errorf("%s: error = %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.read) {
+			t.Errorf("%s: objectids %v before the error, want %v", tt.name, got, tt.read)
+		}
+	}
+}
+
+func TestStreamGeoJSONFeaturesStops(t *testing.T) {
+	stop := errors.New("stop")
+	calls := 0
+	err := StreamGeoJSONFeatures(strings.NewReader(geoJSONPoints(5, "", "")), func(GeoJSONFeature) error {
+		calls++
+		if calls == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || calls != 2 {
+		t.Errorf("error %v after %d features, want stop after 2", err, calls)
+	}
+}
+
+func TestStreamGeoJSONFeaturesBatchBoundaries(t *testing.T) {
+	// Around the import batch size every feature arrives once and in order,
+	// including when the input is read a byte at a time
+	for _, n := range []int{importBatchSize - 1, importBatchSize, importBatchSize + 1, 2 * importBatchSize} {
+		input := geoJSONPoints(n, `"type": "FeatureCollection", `, "")
+		for _, oneByte := range []bool{false, true} {
+			var rd io.Reader = strings.NewReader(input)
+			if oneByte {
+				rd = iotest.OneByteReader(rd)
+			}
+			got, err := streamObjectIDs(rd)
+			if err != nil {
+				t.Fatalf("%d features: %v", n, err)
+			}
+			if len(got) != n {
+				t.Fatalf("%d features: streamed %d", n, len(got))
+			}
+			for i, id := range got {
+				if id != i {
+					t.Fatalf("%d features: feature %d has objectid %d", n, i, id)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pistolricks/api-clients/internal/models"
+
+	// Pure Go SQLite driver, registered as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// ExportGeoPackage is the export format written by NewGeoPackageWriter
+const ExportGeoPackage = "gpkg"
+
+// geoPackageTable and geoPackageGeometry name the feature table and its
+// geometry column
+const (
+	geoPackageTable    = "schools"
+	geoPackageGeometry = "geom"
+)
+
+// geoPackageDateTime is the GeoPackage DATETIME format
+const geoPackageDateTime = "2006-01-02T15:04:05.000Z"
+
+// wgs84WKT is the OGC WKT definition of EPSG:4326
+const wgs84WKT = `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AXIS["Latitude",NORTH],AXIS["Longitude",EAST],AUTHORITY["EPSG","4326"]]`
+
+// geoPackageSchema creates the GeoPackage 1.2 metadata tables with their
+// required spatial reference systems
+const geoPackageSchema = `
+PRAGMA application_id = 1196444487;
+PRAGMA user_version = 10200;
+
+CREATE TABLE gpkg_spatial_ref_sys (
+	srs_name TEXT NOT NULL,
+	srs_id INTEGER NOT NULL PRIMARY KEY,
+	organization TEXT NOT NULL,
+	organization_coordsys_id INTEGER NOT NULL,
+	definition TEXT NOT NULL,
+	description TEXT
+);
+
+CREATE TABLE gpkg_contents (
+	table_name TEXT NOT NULL PRIMARY KEY,
+	data_type TEXT NOT NULL,
+	identifier TEXT UNIQUE,
+	description TEXT DEFAULT '',
+	last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+	min_x DOUBLE,
+	min_y DOUBLE,
+	max_x DOUBLE,
+	max_y DOUBLE,
+	srs_id INTEGER,
+	CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id)
+);
+
+CREATE TABLE gpkg_geometry_columns (
+	table_name TEXT NOT NULL,
+	column_name TEXT NOT NULL,
+	geometry_type_name TEXT NOT NULL,
+	srs_id INTEGER NOT NULL,
+	z TINYINT NOT NULL,
+	m TINYINT NOT NULL,
+	CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name),
+	CONSTRAINT uk_gc_table_name UNIQUE (table_name),
+	CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name),
+	CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id)
+);
+
+CREATE TABLE gpkg_extensions (
+	table_name TEXT,
+	column_name TEXT,
+	extension_name TEXT NOT NULL,
+	definition TEXT NOT NULL,
+	scope TEXT NOT NULL,
+	CONSTRAINT ge_tce UNIQUE (table_name, column_name, extension_name)
+);
+
+INSERT INTO gpkg_spatial_ref_sys VALUES
+	('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', 'undefined cartesian coordinate reference system'),
+	('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', 'undefined geographic coordinate reference system');
+`
+
+// geoPackageRTreeTriggers keep the rtree_schools_geom spatial index in step
+// with edits made by GIS applications, which provide the ST_ functions
+const geoPackageRTreeTriggers = `
+CREATE TRIGGER rtree_schools_geom_insert AFTER INSERT ON schools
+WHEN (new.geom NOT NULL AND NOT ST_IsEmpty(NEW.geom))
+BEGIN
+	INSERT OR REPLACE INTO rtree_schools_geom VALUES (
+		NEW.fid, ST_MinX(NEW.geom), ST_MaxX(NEW.geom), ST_MinY(NEW.geom), ST_MaxY(NEW.geom)
+	);
+END;
+
+CREATE TRIGGER rtree_schools_geom_update1 AFTER UPDATE OF geom ON schools
+WHEN OLD.fid = NEW.fid AND (NEW.geom NOTNULL AND NOT ST_IsEmpty(NEW.geom))
+BEGIN
+	INSERT OR REPLACE INTO rtree_schools_geom VALUES (
+		NEW.fid, ST_MinX(NEW.geom), ST_MaxX(NEW.geom), ST_MinY(NEW.geom), ST_MaxY(NEW.geom)
+	);
+END;
+
+CREATE TRIGGER rtree_schools_geom_update2 AFTER UPDATE OF geom ON schools
+WHEN OLD.fid = NEW.fid AND (NEW.geom ISNULL OR ST_IsEmpty(NEW.geom))
+BEGIN
+	DELETE FROM rtree_schools_geom WHERE id = OLD.fid;
+END;
+
+CREATE TRIGGER rtree_schools_geom_update3 AFTER UPDATE ON schools
+WHEN OLD.fid != NEW.fid AND (NEW.geom NOTNULL AND NOT ST_IsEmpty(NEW.geom))
+BEGIN
+	DELETE FROM rtree_schools_geom WHERE id = OLD.fid;
+	INSERT OR REPLACE INTO rtree_schools_geom VALUES (
+		NEW.fid, ST_MinX(NEW.geom), ST_MaxX(NEW.geom), ST_MinY(NEW.geom), ST_MaxY(NEW.geom)
+	);
+END;
+
+CREATE TRIGGER rtree_schools_geom_update4 AFTER UPDATE ON schools
+WHEN OLD.fid != NEW.fid AND (NEW.geom ISNULL OR ST_IsEmpty(NEW.geom))
+BEGIN
+	DELETE FROM rtree_schools_geom WHERE id IN (OLD.fid, NEW.fid);
+END;
+
+CREATE TRIGGER rtree_schools_geom_delete AFTER DELETE ON schools
+WHEN old.geom NOT NULL
+BEGIN
+	DELETE FROM rtree_schools_geom WHERE id = OLD.fid;
+END;
+`
+
+// geoPackageWriter writes schools to the point feature table of a new
+// GeoPackage file
+type geoPackageWriter struct {
+	path   string
+	db     *sql.DB
+	tx     *sql.Tx
+	insert *sql.Stmt
+	count  int
+	extent BoundingBox
+}
+
+// NewGeoPackageWriter creates a GeoPackage at path, which must not exist,
+// with a schools point layer holding every models.SchoolFields attribute.
+// The spatial index and layer extent are written by Close.
+func NewGeoPackageWriter(path string) (SchoolWriter, error) {
+	if err := ensureNewFile("GeoPackage", path); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GeoPackage: %w", err)
+	}
+	// Keep the pragmas and transaction on one connection
+	db.SetMaxOpenConns(1)
+
+	gw := &geoPackageWriter{
+		path:   path,
+		db:     db,
+		extent: emptyExtent(),
+	}
+	if err := gw.createSchema(); err != nil {
+		db.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to create GeoPackage: %w", err)
+	}
+	return gw, nil
+}
+
+// createSchema creates the metadata and feature tables and starts the
+// transaction the schools are written in
+func (gw *geoPackageWriter) createSchema() error {
+	if _, err := gw.db.Exec(geoPackageSchema); err != nil {
+		return err
+	}
+
+	_, err := gw.db.Exec(
+		"INSERT INTO gpkg_spatial_ref_sys VALUES ('WGS 84 geodetic', 4326, 'EPSG', 4326, ?, 'longitude/latitude coordinates in decimal degrees on the WGS 84 spheroid')",
+		wgs84WKT,
+	)
+	if err != nil {
+		return err
+	}
+
+	columns := []string{
+		"fid INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL",
+		geoPackageGeometry + " POINT",
+	}
+	var empty models.School
+	for _, field := range models.SchoolFields {
+		columns = append(columns, field+" "+geoPackageColumnType(schoolFieldDest(&empty, field)))
+	}
+	if _, err := gw.db.Exec("CREATE TABLE " + geoPackageTable + " (" + strings.Join(columns, ", ") + ")"); err != nil {
+		return err
+	}
+
+	_, err = gw.db.Exec(
+		"INSERT INTO gpkg_contents (table_name, data_type, identifier, description, srs_id) VALUES (?, 'features', ?, 'US public schools', 4326)",
+		geoPackageTable, geoPackageTable,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = gw.db.Exec(
+		"INSERT INTO gpkg_geometry_columns VALUES (?, ?, 'POINT', 4326, 0, 0)",
+		geoPackageTable, geoPackageGeometry,
+	)
+	if err != nil {
+		return err
+	}
+
+	if gw.tx, err = gw.db.Begin(); err != nil {
+		return err
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(models.SchoolFields)+1), ", ")
+	gw.insert, err = gw.tx.Prepare(
+		"INSERT INTO " + geoPackageTable + " (" + geoPackageGeometry + ", " + strings.Join(models.SchoolFields, ", ") + ") VALUES (" + placeholders + ")",
+	)
+	return err
+}
+
+// geoPackageColumnType returns the GeoPackage column type for a School field
+func geoPackageColumnType(field interface{}) string {
+	switch field.(type) {
+	case *int64, *int, *sql.NullInt64:
+		return "INTEGER"
+	case *float64:
+		return "DOUBLE"
+	case *time.Time, *sql.NullTime:
+		return "DATETIME"
+	case *string:
+		return "TEXT NOT NULL"
+	}
+	return "TEXT"
+}
+
+// geoPackageValue returns the value stored for a School field, or nil for null
+func geoPackageValue(field interface{}) interface{} {
+	switch v := field.(type) {
+	case *int64:
+		return *v
+	case *int:
+		return *v
+	case *float64:
+		return *v
+	case *string:
+		return *v
+	case *time.Time:
+		return v.UTC().Format(geoPackageDateTime)
+	case *sql.NullString:
+		if v.Valid {
+			return v.String
+		}
+	case *sql.NullInt64:
+		if v.Valid {
+			return v.Int64
+		}
+	case *sql.NullTime:
+		if v.Valid {
+			return v.Time.UTC().Format(geoPackageDateTime)
+		}
+	}
+	return nil
+}
+
+// geoPackagePoint encodes a point as a GeoPackage binary geometry: the GP
+// header without an envelope followed by little endian WKB
+func geoPackagePoint(lon, lat float64) []byte {
+	buf := make([]byte, 29)
+	copy(buf, "GP")
+	buf[2] = 0 // version 1
+	buf[3] = 1 // little endian, no envelope
+	binary.LittleEndian.PutUint32(buf[4:], 4326)
+	buf[8] = 1 // WKB little endian
+	binary.LittleEndian.PutUint32(buf[9:], 1)
+	binary.LittleEndian.PutUint64(buf[13:], math.Float64bits(lon))
+	binary.LittleEndian.PutUint64(buf[21:], math.Float64bits(lat))
+	return buf
+}
+
+func (gw *geoPackageWriter) WriteSchool(school *models.School) error {
+	values := []interface{}{geoPackagePoint(school.Longitude, school.Latitude)}
+	for _, field := range models.SchoolFields {
+		values = append(values, geoPackageValue(schoolFieldDest(school, field)))
+	}
+	if _, err := gw.insert.Exec(values...); err != nil {
+		return fmt.Errorf("failed to write school %d to GeoPackage: %w", school.ID, err)
+	}
+
+	gw.count++
+	gw.extent.extend(school.Longitude, school.Latitude)
+	return nil
+}
+
+// Close builds the spatial index, records the layer extent and closes the
+// file. The index is filled in one pass before its triggers are created, as
+// the triggers need ST_ functions that only GIS applications provide.
+func (gw *geoPackageWriter) Close() error {
+	if err := gw.finish(); err != nil {
+		gw.abort()
+		return fmt.Errorf("failed to finish GeoPackage: %w", err)
+	}
+	if err := gw.tx.Commit(); err != nil {
+		gw.abort()
+		return fmt.Errorf("failed to finish GeoPackage: %w", err)
+	}
+	if err := gw.db.Close(); err != nil {
+		return fmt.Errorf("failed to finish GeoPackage: %w", err)
+	}
+	return nil
+}
+
+// abort rolls back the schools written so far and removes the file
+func (gw *geoPackageWriter) abort() {
+	gw.insert.Close()
+	gw.tx.Rollback()
+	gw.db.Close()
+	os.Remove(gw.path)
+}
+
+// finish writes the spatial index and extent inside the write transaction
+func (gw *geoPackageWriter) finish() error {
+	gw.insert.Close()
+
+	statements := []string{
+		"CREATE VIRTUAL TABLE rtree_schools_geom USING rtree(id, minx, maxx, miny, maxy)",
+		"INSERT INTO rtree_schools_geom SELECT fid, longitude, longitude, latitude, latitude FROM schools",
+		"INSERT INTO gpkg_extensions VALUES ('schools', 'geom', 'gpkg_rtree_index', 'http://www.geopackage.org/spec120/#extension_rtree', 'write-only')",
+		geoPackageRTreeTriggers,
+	}
+	for _, statement := range statements {
+		if _, err := gw.tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	if gw.count > 0 {
+		_, err := gw.tx.Exec(
+			"UPDATE gpkg_contents SET min_x = ?, min_y = ?, max_x = ?, max_y = ? WHERE table_name = ?",
+			gw.extent.MinLon, gw.extent.MinLat, gw.extent.MaxLon, gw.extent.MaxLat, geoPackageTable,
+		)
+		return err
+	}
+	return nil
+}
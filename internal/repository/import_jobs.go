@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+// importJobColumns is the column list scanned by scanImportJob
+const importJobColumns = `id, status, source, mode, dry_run, processed, inserted, updated, unchanged,
+		deleted, skipped_duplicate, skipped_non_point, failed_validation, failed, issues, error,
+		created_at, started_at, finished_at, updated_at`
+
+// scanImportJob scans a row selected with importJobColumns into an ImportJob
+func scanImportJob(row rowScanner, job *models.ImportJob) error {
+	var source, jobErr sql.NullString
+	var issues []byte
+	err := row.Scan(
+		&job.ID, &job.Status, &source, &job.Mode, &job.DryRun, &job.Processed, &job.Inserted, &job.Updated,
+		&job.Unchanged, &job.Deleted, &job.SkippedDuplicate, &job.SkippedNonPoint,
+		&job.FailedValidation, &job.Failed, &issues, &jobErr,
+		&job.CreatedAt, &job.StartedAt, &job.FinishedAt, &job.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	job.Source = source.String
+	job.Error = jobErr.String
+	if err := json.Unmarshal(issues, &job.Issues); err != nil {
+		return fmt.Errorf("failed to decode import job issues: %w", err)
+	}
+	return nil
+}
+
+// CreateImportJob records a new queued import job
+func (r *SchoolRepository) CreateImportJob(source string, opts ImportOptions) (*models.ImportJob, error) {
+	if opts.Mode == "" {
+		opts.Mode = ImportInsertOnly
+	}
+
+	query := `
+	INSERT INTO import_jobs (status, source, mode, dry_run)
+	VALUES ($1, $2, $3, $4)
+	RETURNING ` + importJobColumns
+
+	row := r.DB.QueryRow(query, models.ImportJobQueued, source, opts.Mode, opts.DryRun)
+
+	var job models.ImportJob
+	if err := scanImportJob(row, &job); err != nil {
+		return nil, fmt.Errorf("failed to create import job: %w", err)
+	}
+	return &job, nil
+}
+
+// GetImportJob retrieves an import job by its ID
+func (r *SchoolRepository) GetImportJob(id int64) (*models.ImportJob, error) {
+	query := `SELECT ` + importJobColumns + ` FROM import_jobs WHERE id = $1`
+
+	var job models.ImportJob
+	if err := scanImportJob(r.DB.QueryRow(query, id), &job); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get import job: %w", err)
+	}
+	return &job, nil
+}
+
+// StartImportJob marks an import job as running
+func (r *SchoolRepository) StartImportJob(id int64) error {
+	_, err := r.DB.Exec(`
+	UPDATE import_jobs
+	SET status = $1, started_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $2
+	`, models.ImportJobRunning, id)
+	if err != nil {
+		return fmt.Errorf("failed to start import job: %w", err)
+	}
+	return nil
+}
+
+// UpdateImportJobProgress stores the latest counts of a running import job
+func (r *SchoolRepository) UpdateImportJobProgress(id int64, stats models.ImportStats) error {
+	issues, err := json.Marshal(issueList(stats))
+	if err != nil {
+		return fmt.Errorf("failed to encode import job issues: %w", err)
+	}
+
+	_, err = r.DB.Exec(`
+	UPDATE import_jobs
+	SET processed = $1, inserted = $2, updated = $3, unchanged = $4, deleted = $5,
+		skipped_duplicate = $6, skipped_non_point = $7, failed_validation = $8,
+		failed = $9, issues = $10, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $11
+	`, stats.Processed, stats.Inserted, stats.Updated, stats.Unchanged, stats.Deleted,
+		stats.SkippedDuplicate, stats.SkippedNonPoint, stats.FailedValidation,
+		stats.Failed, issues, id)
+	if err != nil {
+		return fmt.Errorf("failed to update import job: %w", err)
+	}
+	return nil
+}
+
+// FinishImportJob stores the final counts of an import job. The job is
+// marked failed when importErr is non-nil and completed otherwise.
+func (r *SchoolRepository) FinishImportJob(id int64, stats models.ImportStats, importErr error) error {
+	if err := r.UpdateImportJobProgress(id, stats); err != nil {
+		return err
+	}
+
+	status := models.ImportJobCompleted
+	var message sql.NullString
+	if importErr != nil {
+		status = models.ImportJobFailed
+		message = sql.NullString{String: importErr.Error(), Valid: true}
+	}
+
+	_, err := r.DB.Exec(`
+	UPDATE import_jobs
+	SET status = $1, error = $2, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $3
+	`, status, message, id)
+	if err != nil {
+		return fmt.Errorf("failed to finish import job: %w", err)
+	}
+	return nil
+}
+
+// FailInterruptedImportJobs marks jobs left queued or running by a previous
+// server process as failed. It returns the number of jobs updated.
+func (r *SchoolRepository) FailInterruptedImportJobs() (int64, error) {
+	result, err := r.DB.Exec(`
+	UPDATE import_jobs
+	SET status = $1, error = 'interrupted by server restart',
+		finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE status IN ($2, $3)
+	`, models.ImportJobFailed, models.ImportJobQueued, models.ImportJobRunning)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark interrupted import jobs: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// issueList returns the issues of stats, never nil so they are stored as a
+// JSON array
+func issueList(stats models.ImportStats) []models.ImportIssue {
+	if stats.Issues == nil {
+		return []models.ImportIssue{}
+	}
+	return stats.Issues
+}
@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+// ExportKML is the export format for KML, written by NewKMLWriter
+const ExportKML = "kml"
+
+// KML folder groupings accepted by NewKMLWriter
+const (
+	KMLGroupState = "state"
+	KMLGroupLevel = "level"
+)
+
+// kmlUnknownFolder names the folder of schools without a group value
+const kmlUnknownFolder = "Unknown"
+
+type kmlPlacemark struct {
+	XMLName     xml.Name `xml:"Placemark"`
+	ID          string   `xml:"id,attr"`
+	Name        string   `xml:"name"`
+	Address     string   `xml:"address,omitempty"`
+	Description kmlCDATA `xml:"description"`
+	Point       kmlPoint `xml:"Point"`
+}
+
+type kmlCDATA struct {
+	Text string `xml:",cdata"`
+}
+
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+// kmlSchoolWriter writes a KML document with a Placemark per school, in a
+// Folder per state or level. Schools must arrive ordered by the group, see
+// groupSort, so each Folder is written once.
+type kmlSchoolWriter struct {
+	w       io.Writer
+	encoder *xml.Encoder
+	group   string
+	started bool
+	folder  *string
+}
+
+// NewKMLWriter returns a SchoolWriter for KML that puts schools in a Folder
+// per value of group, KMLGroupState or KMLGroupLevel. An empty group is
+// KMLGroupState.
+func NewKMLWriter(w io.Writer, group string) (SchoolWriter, error) {
+	if group == "" {
+		group = KMLGroupState
+	}
+	if group != KMLGroupState && group != KMLGroupLevel {
+		return nil, fmt.Errorf("unknown KML group %q, expected state or level", group)
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return &kmlSchoolWriter{w: w, encoder: encoder, group: group}, nil
+}
+
+// kmlGroupColumns are the expressions folders are sorted by. Blank values
+// count as missing, matching groupValue, so there is one Unknown folder.
+var kmlGroupColumns = map[string]string{
+	KMLGroupState: "NULLIF(trim(state), '')",
+	KMLGroupLevel: "NULLIF(trim(level), '')",
+}
+
+// groupSort orders schools by the group first so each Folder is contiguous
+func (kw *kmlSchoolWriter) groupSort(sort SchoolSort) SchoolSort {
+	keys := []SortKey{{Field: kw.group, column: kmlGroupColumns[kw.group]}}
+	for _, key := range sort.Keys {
+		if key.Field != kw.group {
+			keys = append(keys, key)
+		}
+	}
+	sort.Keys = keys
+	return sort
+}
+
+// start writes the document header before the first school
+func (kw *kmlSchoolWriter) start() error {
+	if kw.started {
+		return nil
+	}
+	kw.started = true
+
+	// Nothing has been encoded yet, so the header goes straight to w
+	if _, err := io.WriteString(kw.w, xml.Header); err != nil {
+		return err
+	}
+	kml := xml.StartElement{
+		Name: xml.Name{Local: "kml"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: "http://www.opengis.net/kml/2.2"}},
+	}
+	if err := kw.encoder.EncodeToken(kml); err != nil {
+		return err
+	}
+	if err := kw.encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: "Document"}}); err != nil {
+		return err
+	}
+	return kw.encoder.EncodeElement("Schools", xml.StartElement{Name: xml.Name{Local: "name"}})
+}
+
+// groupValue returns the folder name of a school
+func (kw *kmlSchoolWriter) groupValue(school *models.School) string {
+	value := school.State
+	if kw.group == KMLGroupLevel {
+		value = school.Level
+	}
+	if name := strings.TrimSpace(value.String); value.Valid && name != "" {
+		return name
+	}
+	return kmlUnknownFolder
+}
+
+func (kw *kmlSchoolWriter) WriteSchool(school *models.School) error {
+	if err := kw.start(); err != nil {
+		return err
+	}
+
+	if folder := kw.groupValue(school); kw.folder == nil || *kw.folder != folder {
+		if err := kw.endFolder(); err != nil {
+			return err
+		}
+		if err := kw.encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: "Folder"}}); err != nil {
+			return err
+		}
+		if err := kw.encoder.EncodeElement(folder, xml.StartElement{Name: xml.Name{Local: "name"}}); err != nil {
+			return err
+		}
+		kw.folder = &folder
+	}
+
+	response := school.ToResponse()
+	return kw.encoder.Encode(kmlPlacemark{
+		ID:          "school-" + strconv.FormatInt(response.ID, 10),
+		Name:        response.Name,
+		Address:     kmlAddress(response),
+		Description: kmlCDATA{Text: kmlDescription(response)},
+		Point: kmlPoint{
+			Coordinates: strconv.FormatFloat(response.Longitude, 'f', -1, 64) + "," +
+				strconv.FormatFloat(response.Latitude, 'f', -1, 64),
+		},
+	})
+}
+
+// endFolder closes the open Folder, if any
+func (kw *kmlSchoolWriter) endFolder() error {
+	if kw.folder == nil {
+		return nil
+	}
+	kw.folder = nil
+	return kw.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "Folder"}})
+}
+
+func (kw *kmlSchoolWriter) Close() error {
+	if err := kw.start(); err != nil {
+		return err
+	}
+	if err := kw.endFolder(); err != nil {
+		return err
+	}
+	if err := kw.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "Document"}}); err != nil {
+		return err
+	}
+	if err := kw.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "kml"}}); err != nil {
+		return err
+	}
+	return kw.encoder.Close()
+}
+
+// kmlAddress formats the postal address of a school on one line
+func kmlAddress(school models.SchoolResponse) string {
+	stateZip := strings.TrimSpace(school.State + " " + school.Zip)
+
+	var parts []string
+	for _, part := range []string{school.Address, school.City, stateZip} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// kmlDescription builds the HTML shown in a Placemark's balloon: the
+// address and a table of the school's details, leaving out empty values
+func kmlDescription(school models.SchoolResponse) string {
+	grades := school.StartGrade
+	if school.EndGrade != "" && school.EndGrade != school.StartGrade {
+		grades = strings.TrimSpace(grades + " to " + school.EndGrade)
+	}
+
+	rows := [][2]string{
+		{"Level", school.Level},
+		{"Grades", grades},
+		{"Enrollment", kmlCount(school.Enrollment)},
+		{"Full-time teachers", kmlCount(school.FTTeacher)},
+		{"County", school.County},
+		{"Telephone", school.Telephone},
+		{"Website", school.Website},
+		{"NCES ID", school.NCESID},
+		{"District ID", school.DistrictID},
+		{"Type", kmlCount(school.Type)},
+		{"Status", kmlCount(school.Status)},
+		{"Source", school.Source},
+	}
+	if !school.SourceDate.IsZero() {
+		rows = append(rows, [2]string{"Source date", school.SourceDate.Format("2006-01-02")})
+	}
+
+	var b strings.Builder
+	if address := kmlAddress(school); address != "" {
+		fmt.Fprintf(&b, "<p>%s</p>", html.EscapeString(address))
+	}
+	b.WriteString("<table>")
+	for _, row := range rows {
+		if row[1] == "" {
+			continue
+		}
+		value := html.EscapeString(row[1])
+		if row[0] == "Website" && (strings.HasPrefix(row[1], "http://") || strings.HasPrefix(row[1], "https://")) {
+			value = fmt.Sprintf(`<a href="%s">%s</a>`, value, value)
+		}
+		fmt.Fprintf(&b, "<tr><th align=\"left\">%s</th><td>%s</td></tr>", row[0], value)
+	}
+	b.WriteString("</table>")
+	return b.String()
+}
+
+// kmlCount formats a count for the balloon; zero means unknown
+func kmlCount(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,400 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ExportPMTiles is the export format written by ExportPMTiles
+const ExportPMTiles = "pmtiles"
+
+// PMTiles layout constants: the header and root directory must fit in the
+// first 16 KiB so clients can fetch both with one request
+const (
+	pmtilesHeaderSize = 127
+	pmtilesRootMax    = 16384 - pmtilesHeaderSize
+
+	pmtilesCompressionGzip = 2
+	pmtilesTileTypeMVT     = 1
+
+	// pmtilesMaxZoom is the deepest zoom level ExportPMTiles renders
+	pmtilesMaxZoom = 22
+
+	// pmtilesMaxLat is the latitude limit of the Web Mercator tile grid
+	pmtilesMaxLat = 85.05112878
+)
+
+// PMTilesOptions configures ExportPMTiles
+type PMTilesOptions struct {
+	MinZoom int
+	MaxZoom int
+
+	// Attributes are the tile attributes as for SchoolTile
+	Attributes []string
+}
+
+// pmtilesEntry is a directory entry: a run of tiles starting at TileID
+// stored at Offset, or a leaf directory when RunLength is 0
+type pmtilesEntry struct {
+	TileID    uint64
+	Offset    uint64
+	Length    uint32
+	RunLength uint32
+}
+
+// ExportPMTiles writes a PMTiles archive at path, which must not exist,
+// holding a vector tile of the schools matching filter for every tile with
+// at least one school from opts.MinZoom to opts.MaxZoom. Tiles are rendered
+// by the database as for SchoolTile, one query per zoom level, and stored
+// gzip compressed in tile ID order. It returns the number of tiles written.
+func (r *SchoolRepository) ExportPMTiles(path string, filter SchoolFilter, opts PMTilesOptions) (int, error) {
+	if opts.MinZoom < 0 || opts.MinZoom > opts.MaxZoom || opts.MaxZoom > pmtilesMaxZoom {
+		return 0, fmt.Errorf("invalid zoom range %d-%d", opts.MinZoom, opts.MaxZoom)
+	}
+	if len(opts.Attributes) == 0 {
+		opts.Attributes = DefaultTileAttributes
+	}
+	if err := ValidateTileAttributes(opts.Attributes); err != nil {
+		return 0, err
+	}
+	if err := ensureNewFile("PMTiles", path); err != nil {
+		return 0, err
+	}
+
+	extent, err := r.pmtilesExtent(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	// Tiles are collected in a temporary file as they are rendered, since
+	// the directories that precede them are only known at the end
+	data, err := os.CreateTemp(filepath.Dir(path), ".schools-*.pmtiles")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create PMTiles: %w", err)
+	}
+	defer os.Remove(data.Name())
+	defer data.Close()
+
+	buffered := bufio.NewWriter(data)
+	var entries []pmtilesEntry
+	var offset uint64
+	for z := opts.MinZoom; z <= opts.MaxZoom; z++ {
+		err := r.pmtilesZoom(z, filter, opts.Attributes, func(x, y int, mvt []byte) error {
+			compressed, err := gzipBytes(mvt)
+			if err != nil {
+				return fmt.Errorf("failed to compress tile: %w", err)
+			}
+			if _, err := buffered.Write(compressed); err != nil {
+				return fmt.Errorf("failed to write tile: %w", err)
+			}
+			entries = append(entries, pmtilesEntry{
+				TileID:    pmtilesTileID(z, uint32(x), uint32(y)),
+				Offset:    offset,
+				Length:    uint32(len(compressed)),
+				RunLength: 1,
+			})
+			offset += uint64(len(compressed))
+			return nil
+		})
+		if err != nil {
+			return len(entries), err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return len(entries), fmt.Errorf("failed to write tile: %w", err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].TileID < entries[j].TileID })
+	if err := writePMTiles(path, data, entries, extent, opts); err != nil {
+		os.Remove(path)
+		return len(entries), fmt.Errorf("failed to finish PMTiles: %w", err)
+	}
+	return len(entries), nil
+}
+
+// pmtilesExtent returns the extent of the schools matching filter, or the
+// whole tile grid when there are none
+func (r *SchoolRepository) pmtilesExtent(filter SchoolFilter) (BoundingBox, error) {
+	extent := BoundingBox{MinLon: -180, MinLat: -pmtilesMaxLat, MaxLon: 180, MaxLat: pmtilesMaxLat}
+
+	var args queryArgs
+	where := filter.where(&args)
+	var minLon, minLat, maxLon, maxLat sql.NullFloat64
+	err := r.DB.QueryRow(`
+	SELECT ST_XMin(e), ST_YMin(e), ST_XMax(e), ST_YMax(e)
+	FROM (SELECT ST_Extent(location) AS e FROM schools WHERE `+where+`) extent
+	`, args...).Scan(&minLon, &minLat, &maxLon, &maxLat)
+	if err != nil {
+		return extent, fmt.Errorf("failed to compute school extent: %w", err)
+	}
+	if minLon.Valid {
+		extent = BoundingBox{MinLon: minLon.Float64, MinLat: minLat.Float64, MaxLon: maxLon.Float64, MaxLat: maxLat.Float64}
+	}
+	return extent, nil
+}
+
+// pmtilesZoom renders every tile at zoom z holding a school matching filter
+// in one query, grouping the schools by the tile they fall in, and calls fn
+// with each tile in no particular order
+func (r *SchoolRepository) pmtilesZoom(z int, filter SchoolFilter, attributes []string, fn func(x, y int, mvt []byte) error) error {
+	var args queryArgs
+	zoom := args.add(z)
+	n := args.add(float64(int(1) << uint(z)))
+	last := args.add(int(1)<<uint(z) - 1)
+	minLat, maxLat := args.add(-pmtilesMaxLat), args.add(pmtilesMaxLat)
+	layer := args.add(SchoolTileLayer)
+	where := filter.where(&args)
+
+	// The tile of a school is computed as by the Web Mercator tile grid,
+	// with latitudes clamped to the grid
+	query := `
+	SELECT s.x, s.y, ST_AsMVT(mvtgeom.*, ` + layer + `, 4096, 'geom')
+	FROM (
+		SELECT schools.*,
+			LEAST(GREATEST(floor((ST_X(location) + 180) / 360 * ` + n + `)::int, 0), ` + last + `) AS x,
+			LEAST(GREATEST(floor((1 - asinh(tan(radians(
+				LEAST(GREATEST(ST_Y(location), ` + minLat + `), ` + maxLat + `)
+			))) / pi()) / 2 * ` + n + `)::int, 0), ` + last + `) AS y
+		FROM schools
+		WHERE location IS NOT NULL AND ` + where + `
+	) s
+	CROSS JOIN LATERAL (
+		SELECT ST_AsMVTGeom(ST_Transform(s.location, 3857), ST_TileEnvelope(` + zoom + `, s.x, s.y)) AS geom,
+			` + strings.Join(tileColumns(attributes), ", ") + `
+	) mvtgeom
+	GROUP BY s.x, s.y
+	`
+
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to render zoom %d tiles: %w", z, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var x, y int
+		var mvt []byte
+		if err := rows.Scan(&x, &y, &mvt); err != nil {
+			return fmt.Errorf("failed to scan tile: %w", err)
+		}
+		if len(mvt) == 0 {
+			continue
+		}
+		if err := fn(x, y, mvt); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating zoom %d tiles: %w", z, err)
+	}
+	return nil
+}
+
+// pmtilesTileID returns the PMTiles tile ID of z/x/y: the number of tiles
+// at lower zooms plus the position of x, y on the zoom's Hilbert curve
+func pmtilesTileID(z int, x, y uint32) uint64 {
+	id := (uint64(1)<<(2*uint(z)) - 1) / 3
+	n := uint32(1) << uint(z)
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry uint32
+		if x&s != 0 {
+			rx = 1
+		}
+		if y&s != 0 {
+			ry = 1
+		}
+		id += uint64(s) * uint64(s) * uint64((3*rx)^ry)
+
+		// Rotate the quadrant
+		if ry == 0 {
+			if rx == 1 {
+				x, y = n-1-x, n-1-y
+			}
+			x, y = y, x
+		}
+	}
+	return id
+}
+
+// writePMTiles writes the archive: the header, root directory, metadata,
+// leaf directories and the tile data. The entries are in tile ID order
+// with offsets into data, which the tiles are copied from in that order.
+func writePMTiles(path string, data io.ReaderAt, entries []pmtilesEntry, extent BoundingBox, opts PMTilesOptions) error {
+	sources := make([]uint64, len(entries))
+	var dataLength uint64
+	for i := range entries {
+		sources[i] = entries[i].Offset
+		entries[i].Offset = dataLength
+		dataLength += uint64(entries[i].Length)
+	}
+
+	root, leaves, err := pmtilesDirectories(entries)
+	if err != nil {
+		return err
+	}
+	metadata, err := pmtilesMetadata(opts)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, pmtilesHeaderSize)
+	copy(header, "PMTiles")
+	header[7] = 3
+	sections := []uint64{
+		pmtilesHeaderSize, uint64(len(root)),
+		pmtilesHeaderSize + uint64(len(root)), uint64(len(metadata)),
+		pmtilesHeaderSize + uint64(len(root)) + uint64(len(metadata)), uint64(len(leaves)),
+		pmtilesHeaderSize + uint64(len(root)) + uint64(len(metadata)) + uint64(len(leaves)), dataLength,
+		uint64(len(entries)), uint64(len(entries)), uint64(len(entries)),
+	}
+	for i, v := range sections {
+		binary.LittleEndian.PutUint64(header[8+8*i:], v)
+	}
+	header[96] = 1 // clustered: tile data is in tile ID order
+	header[97] = pmtilesCompressionGzip
+	header[98] = pmtilesCompressionGzip
+	header[99] = pmtilesTileTypeMVT
+	header[100] = byte(opts.MinZoom)
+	header[101] = byte(opts.MaxZoom)
+	binary.LittleEndian.PutUint32(header[102:], uint32(int32(math.Round(extent.MinLon*1e7))))
+	binary.LittleEndian.PutUint32(header[106:], uint32(int32(math.Round(extent.MinLat*1e7))))
+	binary.LittleEndian.PutUint32(header[110:], uint32(int32(math.Round(extent.MaxLon*1e7))))
+	binary.LittleEndian.PutUint32(header[114:], uint32(int32(math.Round(extent.MaxLat*1e7))))
+	header[118] = byte(opts.MinZoom)
+	binary.LittleEndian.PutUint32(header[119:], uint32(int32(math.Round((extent.MinLon+extent.MaxLon)/2*1e7))))
+	binary.LittleEndian.PutUint32(header[123:], uint32(int32(math.Round((extent.MinLat+extent.MaxLat)/2*1e7))))
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for _, section := range [][]byte{header, root, metadata, leaves} {
+		if _, err := w.Write(section); err != nil {
+			return err
+		}
+	}
+	for i, e := range entries {
+		if _, err := io.Copy(w, io.NewSectionReader(data, int64(sources[i]), int64(e.Length))); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// pmtilesDirectories serializes the entries as a root directory that fits
+// in the first 16 KiB, splitting them into leaf directories when needed
+func pmtilesDirectories(entries []pmtilesEntry) ([]byte, []byte, error) {
+	root, err := pmtilesDirectory(entries)
+	if err != nil || len(root) <= pmtilesRootMax {
+		return root, nil, err
+	}
+
+	for leafSize := max(4096, len(entries)/3500); ; leafSize += leafSize / 5 {
+		var rootEntries []pmtilesEntry
+		var leaves []byte
+		for start := 0; start < len(entries); start += leafSize {
+			leaf, err := pmtilesDirectory(entries[start:min(start+leafSize, len(entries))])
+			if err != nil {
+				return nil, nil, err
+			}
+			rootEntries = append(rootEntries, pmtilesEntry{
+				TileID: entries[start].TileID,
+				Offset: uint64(len(leaves)),
+				Length: uint32(len(leaf)),
+			})
+			leaves = append(leaves, leaf...)
+		}
+
+		root, err := pmtilesDirectory(rootEntries)
+		if err != nil || len(root) <= pmtilesRootMax {
+			return root, leaves, err
+		}
+	}
+}
+
+// pmtilesDirectory serializes directory entries column by column as
+// varints: the entry count, tile ID deltas, run lengths, lengths and
+// offsets, where 0 means the entry directly follows the previous one and
+// other offsets are stored plus one. The result is gzip compressed.
+func pmtilesDirectory(entries []pmtilesEntry) ([]byte, error) {
+	buf := binary.AppendUvarint(nil, uint64(len(entries)))
+
+	var lastID uint64
+	for _, e := range entries {
+		buf = binary.AppendUvarint(buf, e.TileID-lastID)
+		lastID = e.TileID
+	}
+	for _, e := range entries {
+		buf = binary.AppendUvarint(buf, uint64(e.RunLength))
+	}
+	for _, e := range entries {
+		buf = binary.AppendUvarint(buf, uint64(e.Length))
+	}
+	for i, e := range entries {
+		if i > 0 && e.Offset == entries[i-1].Offset+uint64(entries[i-1].Length) {
+			buf = binary.AppendUvarint(buf, 0)
+		} else {
+			buf = binary.AppendUvarint(buf, e.Offset+1)
+		}
+	}
+
+	return gzipBytes(buf)
+}
+
+// pmtilesMetadata returns the gzip compressed JSON metadata describing the
+// schools vector layer
+func pmtilesMetadata(opts PMTilesOptions) ([]byte, error) {
+	fields := map[string]string{"id": "Number"}
+	for _, attr := range opts.Attributes {
+		fields[attr] = "String"
+		switch attr {
+		case "id", "objectid", "enrollment", "ft_teacher", "type", "status":
+			fields[attr] = "Number"
+		}
+	}
+
+	metadata, err := json.Marshal(map[string]interface{}{
+		"name":        "Schools",
+		"description": "Schools by location",
+		"vector_layers": []map[string]interface{}{{
+			"id":      SchoolTileLayer,
+			"fields":  fields,
+			"minzoom": opts.MinZoom,
+			"maxzoom": opts.MaxZoom,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gzipBytes(metadata)
+}
+
+// gzipBytes returns data gzip compressed
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestPMTilesTileID(t *testing.T) {
+	tests := []struct {
+		z    int
+		x, y uint32
+		want uint64
+	}{
+		{0, 0, 0, 0},
+		{1, 0, 0, 1},
+		{1, 0, 1, 2},
+		{1, 1, 1, 3},
+		{1, 1, 0, 4},
+		{2, 0, 0, 5},
+		{2, 3, 0, 20},
+		{3, 0, 0, 21},
+		{12, 3423, 1763, 19078479},
+	}
+	for _, tt := range tests {
+		if got := pmtilesTileID(tt.z, tt.x, tt.y); got != tt.want {
+			t.Errorf("pmtilesTileID(%d, %d, %d) = %d, want %d", tt.z, tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestPMTilesTileIDCoversZoom(t *testing.T) {
+	// Every tile of a zoom gets a distinct ID between the first IDs of the
+	// zoom and the next one
+	for z := 0; z <= 4; z++ {
+		n := uint32(1) << uint(z)
+		first := pmtilesTileID(z, 0, 0)
+		next := first + uint64(n)*uint64(n)
+		seen := make(map[uint64]bool)
+		for x := uint32(0); x < n; x++ {
+			for y := uint32(0); y < n; y++ {
+				id := pmtilesTileID(z, x, y)
+				if id < first || id >= next || seen[id] {
+					t.Fatalf("pmtilesTileID(%d, %d, %d) = %d, want a new ID in [%d, %d)", z, x, y, id, first, next)
+				}
+				seen[id] = true
+			}
+		}
+	}
+}
+
+func TestPMTilesDirectory(t *testing.T) {
+	entries := []pmtilesEntry{
+		{TileID: 5, Offset: 0, Length: 10, RunLength: 1},
+		{TileID: 6, Offset: 10, Length: 20, RunLength: 1},
+		{TileID: 9, Offset: 100, Length: 5, RunLength: 1},
+	}
+	compressed, err := pmtilesDirectory(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Count, ID deltas, run lengths, lengths, then offsets where 0 means
+	// contiguous with the previous entry and others are stored plus one
+	var want []byte
+	for _, v := range []uint64{3, 5, 1, 3, 1, 1, 1, 10, 20, 5, 1, 0, 101} {
+		want = binary.AppendUvarint(want, v)
+	}
+	if !bytes.Equal(raw, want) {
+		t.Errorf("pmtilesDirectory = %v, want %v", raw, want)
+	}
+}
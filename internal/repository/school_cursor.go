@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidCursor is returned for cursors that cannot be decoded or were
+// issued for a different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// listCursor is the position after the last school of a page: the values of
+// its sort keys and its id
+type listCursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+	ID     int64         `json:"id"`
+}
+
+// signature identifies the sort order a cursor was issued for
+func (s SchoolSort) signature() string {
+	var keys []string
+	distance := false
+	for _, key := range s.Keys {
+		if key.Desc {
+			keys = append(keys, "-"+key.Field)
+		} else {
+			keys = append(keys, key.Field)
+		}
+		distance = distance || key.Field == sortDistance
+	}
+
+	signature := strings.Join(keys, ",")
+	if distance {
+		signature += fmt.Sprintf("@%g,%g", s.Origin.Lat, s.Origin.Lon)
+	}
+	return signature
+}
+
+// encodeCursor returns the opaque cursor for a position in sort order
+func (s SchoolSort) encodeCursor(values []interface{}, id int64) (string, error) {
+	data, err := json.Marshal(listCursor{Sort: s.signature(), Values: values, ID: id})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor parses a cursor issued by encodeCursor for the same sort
+func (s SchoolSort) decodeCursor(cursor string) (listCursor, error) {
+	var c listCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	// Numbers are kept as text so integer keys are passed back exactly
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.Sort != s.signature() || len(c.Values) != len(s.Keys) {
+		return c, fmt.Errorf("%w: issued for a different sort", ErrInvalidCursor)
+	}
+
+	return c, nil
+}
+
+// after returns the condition selecting the schools that sort after the
+// cursor position. Missing values sort last, so a school with a missing key
+// follows every school that has one.
+func (s SchoolSort) after(c listCursor, args *queryArgs) string {
+	condition := "id > " + args.add(c.ID)
+
+	// Build from the last key outwards: each key either sorts strictly after
+	// the cursor or ties with it and defers to the keys that follow
+	for i := len(s.Keys) - 1; i >= 0; i-- {
+		key := s.Keys[i]
+		expr := s.expression(key, args)
+
+		if c.Values[i] == nil {
+			condition = fmt.Sprintf("(%s IS NULL AND %s)", expr, condition)
+			continue
+		}
+
+		op := ">"
+		if key.Desc {
+			op = "<"
+		}
+		value := args.add(c.Values[i])
+		condition = fmt.Sprintf("(%s %s %s OR %s IS NULL OR (%s = %s AND %s))",
+			expr, op, value, expr, expr, value, condition)
+	}
+
+	return condition
+}
+
+// sortValues returns the select list of the sort key values, used to build
+// the cursor of the last school on a page
+func (s SchoolSort) sortValues(args *queryArgs) string {
+	var exprs []string
+	for i, key := range s.Keys {
+		exprs = append(exprs, fmt.Sprintf("%s AS sort_%d", s.expression(key, args), i))
+	}
+	return strings.Join(exprs, ", ")
+}
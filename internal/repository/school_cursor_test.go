@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSchoolSortSignature(t *testing.T) {
+	origin := &Point{Lat: 40.5, Lon: -74}
+	tests := []struct {
+		sort SchoolSort
+		want string
+	}{
+		{SchoolSort{}, ""},
+		{SchoolSort{Keys: []SortKey{{Field: "name"}}}, "name"},
+		{SchoolSort{Keys: []SortKey{{Field: "state"}, {Field: "enrollment", Desc: true}}}, "state,-enrollment"},
+		{SchoolSort{Keys: []SortKey{{Field: "distance"}}, Origin: origin}, "distance@40.5,-74"},
+		{SchoolSort{Keys: []SortKey{{Field: "name", Desc: true}}, Origin: origin}, "-name"},
+	}
+	for _, tt := range tests {
+		if got := tt.sort.signature(); got != tt.want {
+			t.Errorf("signature of %v = %q, want %q", tt.sort.Keys, got, tt.want)
+		}
+	}
+}
+
+func TestSchoolCursorRoundTrip(t *testing.T) {
+	sort := SchoolSort{Keys: []SortKey{
+		{Field: "state"}, {Field: "enrollment", Desc: true}, {Field: "level"}, {Field: "distance"},
+	}, Origin: &Point{Lat: 1, Lon: 2}}
+
+	cursor, err := sort.encodeCursor([]interface{}{"CA", int64(9007199254740993), nil, 12.5}, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := sort.decodeCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Numbers come back as text so integers beyond float precision survive
+	want := []interface{}{"CA", json.Number("9007199254740993"), nil, json.Number("12.5")}
+	if !reflect.DeepEqual(c.Values, want) || c.ID != 42 {
+		t.Errorf("decoded %v id %d, want %v id 42", c.Values, c.ID, want)
+	}
+}
+
+func TestSchoolCursorInvalid(t *testing.T) {
+	byName := SchoolSort{Keys: []SortKey{{Field: "name"}}}
+	encode := func(sort SchoolSort, values []interface{}) string {
+		cursor, err := sort.encodeCursor(values, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cursor
+	}
+	raw := func(data string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(data))
+	}
+
+	tests := []struct {
+		name   string
+		sort   SchoolSort
+		cursor string
+	}{
+		{"not base64", byName, "not a cursor!"},
+		{"not JSON", byName, raw("name")},
+		{"wrong value type", byName, raw(`{"s":"name","v":"a","id":1}`)},
+		{"other key", byName, encode(SchoolSort{Keys: []SortKey{{Field: "city"}}}, []interface{}{"a"})},
+		{"other direction", byName, encode(SchoolSort{Keys: []SortKey{{Field: "name", Desc: true}}}, []interface{}{"a"})},
+		{"missing value", byName, raw(`{"s":"name","v":[],"id":1}`)},
+		{"extra value", byName, raw(`{"s":"name","v":["a","b"],"id":1}`)},
+		{
+			"other origin",
+			SchoolSort{Keys: []SortKey{{Field: "distance"}}, Origin: &Point{Lat: 1, Lon: 2}},
+			encode(SchoolSort{Keys: []SortKey{{Field: "distance"}}, Origin: &Point{Lat: 1, Lon: 3}}, []interface{}{10.0}),
+		},
+	}
+	for _, tt := range tests {
+		if _, err := tt.sort.decodeCursor(tt.cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: decodeCursor error = %v, want ErrInvalidCursor", tt.name, err)
+		}
+	}
+}
+
+func TestSchoolSortAfter(t *testing.T) {
+	// A single key: the cursor value is $2 after the id in $1
+	fields := []string{"distance"}
+	for field := range sortColumns {
+		fields = append(fields, field)
+	}
+	origin := &Point{Lat: 40.5, Lon: -74}
+
+	for _, field := range fields {
+		for _, desc := range []bool{false, true} {
+			sort := SchoolSort{Keys: []SortKey{{Field: field, Desc: desc}}, Origin: origin}
+
+			expr, valueArg, wantArgs := sortColumns[field], "$2", queryArgs{int64(7), "x"}
+			if field == sortDistance {
+				expr = "ST_Distance(location::geography, ST_SetSRID(ST_MakePoint($2, $3), 4326)::geography)"
+				valueArg, wantArgs = "$4", queryArgs{int64(7), origin.Lon, origin.Lat, "x"}
+			}
+			op := ">"
+			if desc {
+				op = "<"
+			}
+
+			// Schools after the value, then those missing it, then ties
+			// broken by id
+			var args queryArgs
+			got := sort.after(listCursor{Values: []interface{}{"x"}, ID: 7}, &args)
+			want := fmt.Sprintf("(%s %s %s OR %s IS NULL OR (%s = %s AND id > $1))", expr, op, valueArg, expr, expr, valueArg)
+			if got != want || !reflect.DeepEqual(args, wantArgs) {
+				t.Errorf("%s desc=%v: after = %s %v, want %s %v", field, desc, got, args, want, wantArgs)
+			}
+
+			// A cursor missing the value is among the last schools, which
+			// are only ordered by id
+			args = nil
+			got = sort.after(listCursor{Values: []interface{}{nil}, ID: 7}, &args)
+			want = fmt.Sprintf("(%s IS NULL AND id > $1)", expr)
+			if got != want || len(args) != len(wantArgs)-1 {
+				t.Errorf("%s desc=%v: after null = %s %v, want %s", field, desc, got, args, want)
+			}
+		}
+	}
+}
+
+func TestSchoolSortAfterKeys(t *testing.T) {
+	state := "state"
+	tests := []struct {
+		name     string
+		sort     SchoolSort
+		values   []interface{}
+		want     string
+		wantArgs queryArgs
+	}{
+		{
+			name:     "no keys",
+			sort:     SchoolSort{},
+			want:     "id > $1",
+			wantArgs: queryArgs{int64(7)},
+		},
+		{
+			name:   "two keys",
+			sort:   SchoolSort{Keys: []SortKey{{Field: "state"}, {Field: "enrollment", Desc: true}}},
+			values: []interface{}{"CA", json.Number("300")},
+			want: "(" + state + " > $3 OR " + state + " IS NULL OR (" + state + " = $3 AND " +
+				"(enrollment < $2 OR enrollment IS NULL OR (enrollment = $2 AND id > $1))))",
+			wantArgs: queryArgs{int64(7), json.Number("300"), "CA"},
+		},
+		{
+			name:   "second key missing",
+			sort:   SchoolSort{Keys: []SortKey{{Field: "state"}, {Field: "enrollment", Desc: true}}},
+			values: []interface{}{"CA", nil},
+			want: "(" + state + " > $2 OR " + state + " IS NULL OR (" + state + " = $2 AND " +
+				"(enrollment IS NULL AND id > $1)))",
+			wantArgs: queryArgs{int64(7), "CA"},
+		},
+		{
+			name:     "first key missing",
+			sort:     SchoolSort{Keys: []SortKey{{Field: "state"}, {Field: "enrollment", Desc: true}}},
+			values:   []interface{}{nil, json.Number("300")},
+			want:     "(" + state + " IS NULL AND (enrollment < $2 OR enrollment IS NULL OR (enrollment = $2 AND id > $1)))",
+			wantArgs: queryArgs{int64(7), json.Number("300")},
+		},
+	}
+	for _, tt := range tests {
+		var args queryArgs
+		got := tt.sort.after(listCursor{Values: tt.values, ID: 7}, &args)
+		if got != tt.want || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("%s: after =\n%s %v\nwant\n%s %v", tt.name, got, args, tt.want, tt.wantArgs)
+		}
+	}
+}
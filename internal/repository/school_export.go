@@ -0,0 +1,275 @@
+package repository
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+// Export formats accepted by NewSchoolWriter
+const (
+	ExportCSV     = "csv"
+	ExportNDJSON  = "ndjson"
+	ExportGeoJSON = "geojson"
+)
+
+// StreamSchools calls fn for each school matching filter in sort order as
+// it is read from the database, so memory use does not grow with the number
+// of schools. It stops at the first error returned by fn.
+func (r *SchoolRepository) StreamSchools(filter SchoolFilter, sort SchoolSort, fn func(*models.School) error) error {
+	var args queryArgs
+	where := filter.where(&args)
+
+	query := `
+	SELECT ` + schoolColumns + `
+	FROM schools
+	WHERE ` + where + `
+	ORDER BY ` + sort.orderBy(&args)
+
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to export schools: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var school models.School
+		if err := scanSchool(rows, &school); err != nil {
+			return fmt.Errorf("failed to scan school: %w", err)
+		}
+		if err := fn(&school); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating schools: %w", err)
+	}
+	return nil
+}
+
+// ExportSchools writes the schools matching filter in sort order with sw
+// and closes it. It returns the number of schools written.
+func (r *SchoolRepository) ExportSchools(filter SchoolFilter, sort SchoolSort, sw SchoolWriter) (int, error) {
+	if grouped, ok := sw.(groupedSchoolWriter); ok {
+		sort = grouped.groupSort(sort)
+	}
+
+	count := 0
+	err := r.StreamSchools(filter, sort, func(school *models.School) error {
+		count++
+		return sw.WriteSchool(school)
+	})
+
+	// A failed export is abandoned rather than finished, so file based
+	// writers leave no partial file behind
+	if aborter, ok := sw.(abortableSchoolWriter); ok && err != nil {
+		aborter.abort()
+		return count, err
+	}
+	// Close even after a failure so other writers release their output
+	if closeErr := sw.Close(); err == nil {
+		err = closeErr
+	}
+	return count, err
+}
+
+// SchoolWriter writes exported schools one at a time. Close finishes the
+// output; it does not close the underlying writer.
+type SchoolWriter interface {
+	WriteSchool(school *models.School) error
+	Close() error
+}
+
+// groupedSchoolWriter is a SchoolWriter that needs schools ordered by a
+// group first, such as KML with its folders
+type groupedSchoolWriter interface {
+	SchoolWriter
+	groupSort(sort SchoolSort) SchoolSort
+}
+
+// abortableSchoolWriter is a SchoolWriter that writes a file and can
+// abandon it, such as GeoPackage. abort is used instead of Close after a
+// failed export and removes everything written.
+type abortableSchoolWriter interface {
+	SchoolWriter
+	abort()
+}
+
+// NewSchoolWriter returns a SchoolWriter for an export format. KML is
+// grouped by state; use NewKMLWriter to group it by level.
+func NewSchoolWriter(format string, w io.Writer) (SchoolWriter, error) {
+	switch format {
+	case ExportCSV:
+		return newCSVSchoolWriter(w), nil
+	case ExportNDJSON:
+		return &ndjsonSchoolWriter{encoder: json.NewEncoder(w)}, nil
+	case ExportGeoJSON:
+		return &geoJSONSchoolWriter{w: bufio.NewWriter(w)}, nil
+	case ExportKML:
+		return NewKMLWriter(w, KMLGroupState)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// IsFileExportFormat reports whether format is written by
+// NewSchoolFileWriter
+func IsFileExportFormat(format string) bool {
+	return format == ExportGeoPackage || format == ExportShapefile || format == ExportFlatGeobuf
+}
+
+// ExportExtension returns the file name extension of an export format
+func ExportExtension(format string) string {
+	if format == ExportShapefile {
+		return "zip"
+	}
+	return format
+}
+
+// NewSchoolFileWriter returns a SchoolWriter for an export format that is
+// written to a file at path rather than streamed
+func NewSchoolFileWriter(format, path string) (SchoolWriter, error) {
+	switch format {
+	case ExportGeoPackage:
+		return NewGeoPackageWriter(path)
+	case ExportShapefile:
+		return NewShapefileWriter(path)
+	case ExportFlatGeobuf:
+		return NewFlatGeobufWriter(path)
+	}
+	return nil, fmt.Errorf("unknown file export format %q", format)
+}
+
+// ensureNewFile checks that nothing exists at path, where a file of the
+// named kind is about to be written
+func ensureNewFile(kind, path string) error {
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("failed to create %s: %s already exists", kind, path)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to create %s: %w", kind, err)
+	}
+	return nil
+}
+
+// csvSchoolWriter writes one record per school with a column per
+// models.SchoolFields entry, so the output can be imported again with the
+// default CSV mapping
+type csvSchoolWriter struct {
+	writer *csv.Writer
+	header bool
+	record []string
+}
+
+func newCSVSchoolWriter(w io.Writer) *csvSchoolWriter {
+	return &csvSchoolWriter{writer: csv.NewWriter(w), record: make([]string, len(models.SchoolFields))}
+}
+
+// writeHeader writes the header record before the first school
+func (cw *csvSchoolWriter) writeHeader() error {
+	if cw.header {
+		return nil
+	}
+	cw.header = true
+	return cw.writer.Write(models.SchoolFields)
+}
+
+func (cw *csvSchoolWriter) WriteSchool(school *models.School) error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+	for i, field := range models.SchoolFields {
+		cw.record[i] = formatField(schoolFieldDest(school, field))
+	}
+	return cw.writer.Write(cw.record)
+}
+
+func (cw *csvSchoolWriter) Close() error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+	cw.writer.Flush()
+	return cw.writer.Error()
+}
+
+// formatField formats a School field as text; null values are empty
+func formatField(field interface{}) string {
+	switch v := field.(type) {
+	case *int64:
+		return strconv.FormatInt(*v, 10)
+	case *int:
+		return strconv.Itoa(*v)
+	case *string:
+		return *v
+	case *float64:
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	case *time.Time:
+		return v.Format(time.RFC3339)
+	case *sql.NullString:
+		return v.String
+	case *sql.NullInt64:
+		if v.Valid {
+			return strconv.FormatInt(v.Int64, 10)
+		}
+	case *sql.NullTime:
+		if v.Valid {
+			return v.Time.Format(time.RFC3339)
+		}
+	}
+	return ""
+}
+
+// ndjsonSchoolWriter writes one SchoolResponse JSON object per line
+type ndjsonSchoolWriter struct {
+	encoder *json.Encoder
+}
+
+func (nw *ndjsonSchoolWriter) WriteSchool(school *models.School) error {
+	return nw.encoder.Encode(school.ToResponse())
+}
+
+func (nw *ndjsonSchoolWriter) Close() error {
+	return nil
+}
+
+// geoJSONSchoolWriter writes a FeatureCollection one feature at a time
+type geoJSONSchoolWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func (gw *geoJSONSchoolWriter) WriteSchool(school *models.School) error {
+	separator := ",\n"
+	if gw.count == 0 {
+		separator = `{"type":"FeatureCollection","features":[` + "\n"
+	}
+	gw.count++
+
+	data, err := json.Marshal(FeatureFromSchool(school))
+	if err != nil {
+		return err
+	}
+	if _, err := gw.w.WriteString(separator); err != nil {
+		return err
+	}
+	_, err = gw.w.Write(data)
+	return err
+}
+
+func (gw *geoJSONSchoolWriter) Close() error {
+	end := "\n]}\n"
+	if gw.count == 0 {
+		end = `{"type":"FeatureCollection","features":[]}` + "\n"
+	}
+	if _, err := gw.w.WriteString(end); err != nil {
+		return err
+	}
+	return gw.w.Flush()
+}
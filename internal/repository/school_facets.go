@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+// ErrUnknownFacet is returned by Facets for facets not in FacetNames
+var ErrUnknownFacet = errors.New("unknown facet")
+
+// FacetNames are the school attributes that can be counted by Facets. The
+// facet names are also the column names.
+var FacetNames = []string{"state", "level", "status", "type"}
+
+// Facets counts the schools matching filter per value of each facet, most
+// common values first, together with the total number of matching schools.
+// All facets are computed by a single grouping sets query.
+func (r *SchoolRepository) Facets(filter SchoolFilter, facets []string) (int, map[string][]models.FacetCount, error) {
+	seen := make(map[string]bool)
+	for _, facet := range facets {
+		if !slices.Contains(FacetNames, facet) {
+			return 0, nil, fmt.Errorf("%w %q", ErrUnknownFacet, facet)
+		}
+		if seen[facet] {
+			return 0, nil, fmt.Errorf("%w: repeated facet %q", ErrUnknownFacet, facet)
+		}
+		seen[facet] = true
+	}
+
+	var args queryArgs
+	where := filter.where(&args)
+
+	// Each row belongs to the grouping set of the one facet column that is
+	// grouped; the empty set gives the total. Facet values are ordered by
+	// their position, as the other facet columns are NULL in a set.
+	var which, values, sets []string
+	orderBy := []string{"1", "COUNT(*) DESC"}
+	for i, facet := range facets {
+		which = append(which, fmt.Sprintf("WHEN GROUPING(%s) = 0 THEN %d", facet, i))
+		values = append(values, facet+"::TEXT")
+		sets = append(sets, "("+facet+")")
+		orderBy = append(orderBy, fmt.Sprint(i+2))
+	}
+	sets = append(sets, "()")
+
+	selectList := "-1"
+	if len(facets) > 0 {
+		selectList = "CASE " + strings.Join(which, " ") + " ELSE -1 END, " + strings.Join(values, ", ")
+	}
+
+	query := `
+	SELECT ` + selectList + `, COUNT(*)
+	FROM schools
+	WHERE ` + where + `
+	GROUP BY GROUPING SETS (` + strings.Join(sets, ", ") + `)
+	ORDER BY ` + strings.Join(orderBy, ", ")
+
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to count facets: %w", err)
+	}
+	defer rows.Close()
+
+	total := 0
+	counts := make(map[string][]models.FacetCount, len(facets))
+	for _, facet := range facets {
+		counts[facet] = []models.FacetCount{}
+	}
+
+	for rows.Next() {
+		var index, count int
+		facetValues := make([]sql.NullString, len(facets))
+		dest := []interface{}{&index}
+		for i := range facetValues {
+			dest = append(dest, &facetValues[i])
+		}
+		dest = append(dest, &count)
+
+		if err := rows.Scan(dest...); err != nil {
+			return 0, nil, fmt.Errorf("failed to scan facet count: %w", err)
+		}
+
+		if index < 0 {
+			total = count
+			continue
+		}
+
+		facetCount := models.FacetCount{Count: count}
+		if value := facetValues[index]; value.Valid {
+			facetCount.Value = &value.String
+		}
+		counts[facets[index]] = append(counts[facets[index]], facetCount)
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("error iterating facet counts: %w", err)
+	}
+
+	return total, counts, nil
+}
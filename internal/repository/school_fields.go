@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+// ErrUnknownField is returned for a sparse fieldset naming a field that is
+// not one of models.SchoolFields
+var ErrUnknownField = errors.New("unknown field")
+
+// checkFields checks that every field is one of models.SchoolFields
+func checkFields(fields []string) error {
+	for _, field := range fields {
+		if !models.IsSchoolField(field) {
+			return fmt.Errorf("%w %q", ErrUnknownField, field)
+		}
+	}
+	return nil
+}
+
+// schoolFieldDest returns the School field a column of models.SchoolFields
+// scans into
+func schoolFieldDest(school *models.School, field string) interface{} {
+	switch field {
+	case "id":
+		return &school.ID
+	case "objectid":
+		return &school.ObjectID
+	case "name":
+		return &school.Name
+	case "address":
+		return &school.Address
+	case "city":
+		return &school.City
+	case "state":
+		return &school.State
+	case "zip":
+		return &school.Zip
+	case "country":
+		return &school.Country
+	case "county":
+		return &school.County
+	case "countyfips":
+		return &school.CountyFIPS
+	case "latitude":
+		return &school.Latitude
+	case "longitude":
+		return &school.Longitude
+	case "level":
+		return &school.Level
+	case "st_grade":
+		return &school.StartGrade
+	case "end_grade":
+		return &school.EndGrade
+	case "enrollment":
+		return &school.Enrollment
+	case "ft_teacher":
+		return &school.FTTeacher
+	case "type":
+		return &school.Type
+	case "status":
+		return &school.Status
+	case "population":
+		return &school.Population
+	case "ncesid":
+		return &school.NCESID
+	case "districtid":
+		return &school.DistrictID
+	case "naics_code":
+		return &school.NAICSCode
+	case "naics_desc":
+		return &school.NAICSDesc
+	case "website":
+		return &school.Website
+	case "telephone":
+		return &school.Telephone
+	case "sourcedate":
+		return &school.SourceDate
+	case "val_date":
+		return &school.ValDate
+	case "val_method":
+		return &school.ValMethod
+	case "source":
+		return &school.Source
+	case "shelter_id":
+		return &school.ShelterID
+	case "created_at":
+		return &school.CreatedAt
+	case "updated_at":
+		return &school.UpdatedAt
+	case "st_grade_ord":
+		return &school.StartGradeOrd
+	case "end_grade_ord":
+		return &school.EndGradeOrd
+	}
+	return nil
+}
+
+// selectColumns returns the select list for a sparse fieldset, or every
+// column when fields is nil. Field names must be models.SchoolFields; they
+// are the column names.
+func selectColumns(fields []string) string {
+	if fields == nil {
+		return schoolColumns
+	}
+	return strings.Join(fields, ", ")
+}
+
+// scanSchoolFields scans a row selected with selectColumns(fields)
+func scanSchoolFields(row rowScanner, school *models.School, fields []string, extra ...interface{}) error {
+	if fields == nil {
+		return scanSchool(row, school, extra...)
+	}
+
+	dest := make([]interface{}, 0, len(fields)+len(extra))
+	for _, field := range fields {
+		dest = append(dest, schoolFieldDest(school, field))
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// withID returns fields with id added if it is missing. Fields left nil
+// already select every column.
+func withID(fields []string) []string {
+	if fields == nil {
+		return nil
+	}
+	if slices.Contains(fields, "id") {
+		return fields
+	}
+	return append([]string{"id"}, fields...)
+}
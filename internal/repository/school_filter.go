@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// SchoolFilter narrows the schools returned by List and counted by Count.
+// Text filters match any of their values, ignoring case, except the code
+// filters CountyFIPS, Zip and DistrictID which match exactly. Zero values
+// do not filter.
+type SchoolFilter struct {
+	State      []string
+	County     []string
+	CountyFIPS []string
+	City       []string
+	Zip        []string
+	Level      []string
+	DistrictID []string
+	Status     []int64
+	Type       []int64
+
+	EnrollmentMin *int64
+	EnrollmentMax *int64
+	TeacherMin    *int64
+	TeacherMax    *int64
+
+	// Grades restricts the schools to those whose grade span overlaps it
+	Grades *GradeSpan
+
+	// BBox restricts the schools to those inside the box
+	BBox *BoundingBox
+}
+
+// GradeSpan is an inclusive range of grade ordinals, see models.GradeOrdinal
+type GradeSpan struct {
+	Low  int
+	High int
+}
+
+// queryArgs collects the arguments of a query as its placeholders are written
+type queryArgs []interface{}
+
+// add appends an argument and returns its placeholder
+func (a *queryArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// where returns the filter's conditions joined with AND, adding their
+// arguments to args. It returns "TRUE" when nothing is filtered.
+func (f SchoolFilter) where(args *queryArgs) string {
+	var conditions []string
+
+	anyText := func(column string, values []string) {
+		if len(values) > 0 {
+			lowered := make([]string, len(values))
+			for i, v := range values {
+				lowered[i] = strings.ToLower(v)
+			}
+			conditions = append(conditions, fmt.Sprintf("lower(%s) = ANY(%s)", column, args.add(pq.Array(lowered))))
+		}
+	}
+	anyCode := func(column string, values []string) {
+		if len(values) > 0 {
+			conditions = append(conditions, fmt.Sprintf("%s = ANY(%s)", column, args.add(pq.Array(values))))
+		}
+	}
+	anyInt := func(column string, values []int64) {
+		if len(values) > 0 {
+			conditions = append(conditions, fmt.Sprintf("%s = ANY(%s)", column, args.add(pq.Array(values))))
+		}
+	}
+	bound := func(column, op string, value *int64) {
+		if value != nil {
+			conditions = append(conditions, fmt.Sprintf("%s %s %s", column, op, args.add(*value)))
+		}
+	}
+
+	anyText("state", f.State)
+	anyText("county", f.County)
+	anyCode("countyfips", f.CountyFIPS)
+	anyText("city", f.City)
+	anyCode("zip", f.Zip)
+	anyText("level", f.Level)
+	anyCode("districtid", f.DistrictID)
+	anyInt("status", f.Status)
+	anyInt("type", f.Type)
+	bound("enrollment", ">=", f.EnrollmentMin)
+	bound("enrollment", "<=", f.EnrollmentMax)
+	bound("ft_teacher", ">=", f.TeacherMin)
+	bound("ft_teacher", "<=", f.TeacherMax)
+
+	if f.Grades != nil {
+		conditions = append(conditions, fmt.Sprintf(
+			"st_grade_ord <= %s AND end_grade_ord >= %s", args.add(f.Grades.High), args.add(f.Grades.Low),
+		))
+	}
+
+	// The && operator lets the planner use schools_location_idx
+	if f.BBox != nil {
+		conditions = append(conditions, fmt.Sprintf(
+			"location && ST_MakeEnvelope(%s, %s, %s, %s, 4326)",
+			args.add(f.BBox.MinLon), args.add(f.BBox.MinLat), args.add(f.BBox.MaxLon), args.add(f.BBox.MaxLat),
+		))
+	}
+
+	if len(conditions) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(conditions, " AND ")
+}
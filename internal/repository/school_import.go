@@ -0,0 +1,531 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+// ImportMode controls how imported schools are written
+type ImportMode string
+
+const (
+	// ImportInsertOnly adds new schools and leaves existing ones untouched
+	ImportInsertOnly ImportMode = "insert-only"
+
+	// ImportUpsert adds new schools and updates existing ones that changed
+	ImportUpsert ImportMode = "upsert"
+
+	// ImportReplace upserts like ImportUpsert, then deletes every school
+	// that was not in the import
+	ImportReplace ImportMode = "replace"
+)
+
+// ParseImportMode parses an import mode name; the empty string is insert-only
+func ParseImportMode(value string) (ImportMode, error) {
+	switch mode := ImportMode(value); mode {
+	case "":
+		return ImportInsertOnly, nil
+	case ImportInsertOnly, ImportUpsert, ImportReplace:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown import mode %q: use insert-only, upsert or replace", value)
+}
+
+// ErrIncompleteReplace is returned by replace imports in which some schools
+// were rejected or skipped. Their stored schools would count as missing
+// from the import, so nothing is deleted.
+var ErrIncompleteReplace = errors.New("incomplete replace import")
+
+// ImportKey is the column imported schools are matched on: the conflict
+// key of upserts and the key kept by replace
+type ImportKey string
+
+const (
+	// ImportKeyObjectID matches schools on the source data's objectid
+	ImportKeyObjectID ImportKey = "objectid"
+
+	// ImportKeyNCESID matches schools on their NCES school ID, for sources
+	// such as the Common Core of Data that have no objectid
+	ImportKeyNCESID ImportKey = "ncesid"
+)
+
+// value returns a school's key as text, and false when it is missing
+func (k ImportKey) value(school *models.School) (string, bool) {
+	if k == ImportKeyNCESID {
+		return school.NCESID.String, school.NCESID.Valid && school.NCESID.String != ""
+	}
+	return strconv.Itoa(school.ObjectID), school.ObjectID > 0
+}
+
+// arrayType is the SQL type of an array of keys
+func (k ImportKey) arrayType() string {
+	if k == ImportKeyNCESID {
+		return "text[]"
+	}
+	return "bigint[]"
+}
+
+// ImportOptions configures an import
+type ImportOptions struct {
+	// Mode is how schools are written; the zero value is insert-only
+	Mode ImportMode
+
+	// Key is the column schools are matched on; the zero value is objectid
+	Key ImportKey
+
+	// Fields are the school fields the source provides, by JSON name. Only
+	// these are updated on existing schools, so a source with fewer fields
+	// does not clear the others. Nil means every field.
+	Fields []string
+
+	// DryRun validates and counts what the import would do without changing
+	// anything: schools are only compared with the stored ones, nothing is
+	// written or locked
+	DryRun bool
+
+	// Progress, if set, is called with the running counts after each batch
+	Progress func(models.ImportStats)
+}
+
+// importBatchSize is the number of schools written per transaction
+const importBatchSize = 1000
+
+// importColumns are the schools columns written by an import, in the order
+// of importValues. The import key is one of them.
+var importColumns = []string{
+	"objectid", "name", "address", "city", "state", "zip", "country", "county", "countyfips",
+	"latitude", "longitude", "level", "st_grade", "end_grade", "enrollment", "ft_teacher",
+	"type", "status", "population", "ncesid", "districtid", "naics_code", "naics_desc",
+	"website", "telephone", "sourcedate", "val_date", "val_method", "source", "shelter_id",
+}
+
+// importValues returns the values of importColumns for a school
+func importValues(school *models.School) []interface{} {
+	// Sources keyed on ncesid have no objectid
+	objectID := sql.NullInt64{Int64: int64(school.ObjectID), Valid: school.ObjectID != 0}
+	return []interface{}{
+		objectID, school.Name, school.Address, school.City, school.State,
+		school.Zip, school.Country, school.County, school.CountyFIPS, school.Latitude,
+		school.Longitude, school.Level, school.StartGrade, school.EndGrade, school.Enrollment,
+		school.FTTeacher, school.Type, school.Status, school.Population, school.NCESID,
+		school.DistrictID, school.NAICSCode, school.NAICSDesc, school.Website, school.Telephone,
+		school.SourceDate, school.ValDate, school.ValMethod, school.Source, school.ShelterID,
+	}
+}
+
+// importStatement builds the statement that writes one school. It returns
+// whether the row was inserted (true) or updated (false), and no row when
+// the existing school was left untouched.
+func importStatement(opts ImportOptions) string {
+	placeholders := make([]string, len(importColumns))
+	for i := range importColumns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	conflict := "DO NOTHING"
+	if opts.Mode != ImportInsertOnly {
+		// Only rewrite rows whose attributes actually changed
+		var sets, current, incoming []string
+		for _, column := range updateColumns(opts) {
+			sets = append(sets, column+" = EXCLUDED."+column)
+			current = append(current, "schools."+column)
+			incoming = append(incoming, "EXCLUDED."+column)
+		}
+		conflict = `DO UPDATE SET ` + strings.Join(sets, ", ") + `,
+		location = EXCLUDED.location, updated_at = CURRENT_TIMESTAMP
+	WHERE (` + strings.Join(current, ", ") + `)
+		IS DISTINCT FROM (` + strings.Join(incoming, ", ") + `)`
+	}
+
+	// Columns 10 and 11 are latitude and longitude
+	return `
+	INSERT INTO schools (` + strings.Join(importColumns, ", ") + `, location)
+	VALUES (` + strings.Join(placeholders, ", ") + `, ST_SetSRID(ST_MakePoint($11, $10), 4326))
+	ON CONFLICT (` + string(opts.Key) + `) ` + conflict + `
+	RETURNING (xmax = 0) AS inserted
+	`
+}
+
+// dryRunStatement builds the query that classifies one school in a dry
+// run without writing it. It returns no row when the school is new, and
+// otherwise whether an upsert would change it. The key is $1 and the
+// values of updateColumns follow.
+func dryRunStatement(opts ImportOptions) string {
+	if opts.Mode == ImportInsertOnly {
+		return `SELECT FALSE FROM schools WHERE ` + string(opts.Key) + ` = $1`
+	}
+
+	columns := updateColumns(opts)
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+	}
+	return `
+	SELECT (` + strings.Join(columns, ", ") + `) IS DISTINCT FROM (` + strings.Join(placeholders, ", ") + `)
+	FROM schools WHERE ` + string(opts.Key) + ` = $1
+	`
+}
+
+// updateColumns are the columns an upsert updates on existing schools:
+// those of the fields the source provides, except the key. Sources keyed
+// on ncesid keep the objectid of the school they update.
+func updateColumns(opts ImportOptions) []string {
+	var columns []string
+	for _, column := range importColumns {
+		if column == string(opts.Key) || (opts.Key == ImportKeyNCESID && column == "objectid") {
+			continue
+		}
+		if opts.Fields != nil && !slices.Contains(opts.Fields, column) {
+			continue
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// pendingSchool is a school waiting to be written and the index of the
+// feature or record it came from
+type pendingSchool struct {
+	index  int
+	school models.School
+}
+
+// schoolImporter validates schools, writes them to the database in batches
+// and keeps count of the outcome. Sources feed it with add and call finish
+// at the end.
+type schoolImporter struct {
+	repo    *SchoolRepository
+	opts    ImportOptions
+	query   string
+	stats   models.ImportStats
+	pending []pendingSchool
+
+	// seen holds the key of every school read in replace mode, including
+	// those rejected
+	seen map[string]struct{}
+
+	// dryRunHashes holds, in a dry run, a hash of the compared values of
+	// every school classified so far by key, standing in for the rows that
+	// would have been written so a repeated key is not counted as a second
+	// insert
+	dryRunHashes map[string]uint64
+}
+
+// newSchoolImporter creates a schoolImporter for the given options
+func (r *SchoolRepository) newSchoolImporter(opts ImportOptions) (*schoolImporter, error) {
+	if opts.Mode == "" {
+		opts.Mode = ImportInsertOnly
+	}
+	if _, err := ParseImportMode(string(opts.Mode)); err != nil {
+		return nil, err
+	}
+	if opts.Key == "" {
+		opts.Key = ImportKeyObjectID
+	}
+	if opts.Key != ImportKeyObjectID && opts.Key != ImportKeyNCESID {
+		return nil, fmt.Errorf("unknown import key %q", opts.Key)
+	}
+
+	importer := &schoolImporter{
+		repo:    r,
+		opts:    opts,
+		query:   importStatement(opts),
+		pending: make([]pendingSchool, 0, importBatchSize),
+	}
+	if opts.DryRun {
+		importer.query = dryRunStatement(opts)
+		importer.dryRunHashes = make(map[string]uint64)
+	}
+	if opts.Mode == ImportReplace {
+		importer.seen = make(map[string]struct{})
+	}
+	return importer, nil
+}
+
+// add validates the school read from the feature or record at index and
+// queues it, writing the batch once it is full
+func (imp *schoolImporter) add(index int, school models.School) error {
+	if _, ok := imp.opts.Key.value(&school); !ok {
+		imp.reject(index, &school, fmt.Errorf("missing %s", imp.opts.Key))
+		return nil
+	}
+	if err := school.Validate(); err != nil {
+		imp.reject(index, &school, err)
+		return nil
+	}
+	imp.keep(&school)
+
+	imp.pending = append(imp.pending, pendingSchool{index: index, school: school})
+	if len(imp.pending) >= importBatchSize {
+		return imp.flush()
+	}
+	return nil
+}
+
+// reject counts the school read from the feature or record at index as
+// failing validation
+func (imp *schoolImporter) reject(index int, school *models.School, err error) {
+	imp.keep(school)
+	imp.stats.FailedValidation++
+	imp.stats.AddIssue(index, school.ObjectID, err.Error())
+}
+
+// keep records the key of a school read in replace mode, whether or not it
+// is valid
+func (imp *schoolImporter) keep(school *models.School) {
+	if key, ok := imp.opts.Key.value(school); ok && imp.seen != nil {
+		imp.seen[key] = struct{}{}
+	}
+}
+
+// flush writes the queued schools and reports progress
+func (imp *schoolImporter) flush() error {
+	if len(imp.pending) == 0 {
+		return nil
+	}
+	if err := imp.writeBatch(imp.pending); err != nil {
+		return err
+	}
+	imp.pending = imp.pending[:0]
+	if imp.opts.Progress != nil {
+		imp.opts.Progress(imp.stats)
+	}
+	return nil
+}
+
+// finish writes the remaining schools. In replace mode it then deletes the
+// schools with a key that was not imported, or counts them in a dry run.
+// Nothing is deleted when a school was rejected or skipped.
+func (imp *schoolImporter) finish() error {
+	if err := imp.flush(); err != nil {
+		return err
+	}
+	if imp.seen == nil {
+		return nil
+	}
+	if skipped := imp.stats.SkippedNonPoint + imp.stats.FailedValidation + imp.stats.Failed; skipped > 0 {
+		return fmt.Errorf("%w: %d schools were not imported, so none were deleted", ErrIncompleteReplace, skipped)
+	}
+
+	keys := make([]string, 0, len(imp.seen))
+	for key := range imp.seen {
+		keys = append(keys, key)
+	}
+
+	column := string(imp.opts.Key)
+	where := column + " IS NOT NULL AND NOT (" + column + " = ANY($1::" + imp.opts.Key.arrayType() + "))"
+	if imp.opts.DryRun {
+		err := imp.repo.DB.QueryRow("SELECT count(*) FROM schools WHERE "+where, pq.Array(keys)).Scan(&imp.stats.Deleted)
+		if err != nil {
+			return fmt.Errorf("error counting replaced schools: %w", err)
+		}
+	} else {
+		result, err := imp.repo.DB.Exec("DELETE FROM schools WHERE "+where, pq.Array(keys))
+		if err != nil {
+			return fmt.Errorf("error deleting replaced schools: %w", err)
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			imp.stats.Deleted = int(n)
+		}
+	}
+
+	if imp.opts.Progress != nil {
+		imp.opts.Progress(imp.stats)
+	}
+	return nil
+}
+
+// writeBatch writes schools in one transaction and adds the outcome to the
+// stats. If the batch fails because of bad data it is retried one school at
+// a time, so a single bad school only fails itself.
+func (imp *schoolImporter) writeBatch(schools []pendingSchool) error {
+	outcome, err := imp.apply(schools)
+	if err == nil {
+		imp.record(outcome)
+		return nil
+	}
+	if !isDataError(err) {
+		return err
+	}
+
+	for i := range schools {
+		outcome, err := imp.apply(schools[i : i+1])
+		if err != nil {
+			if !isDataError(err) {
+				return err
+			}
+			imp.stats.Failed++
+			imp.stats.AddIssue(schools[i].index, schools[i].school.ObjectID, err.Error())
+			continue
+		}
+		imp.record(outcome)
+	}
+	return nil
+}
+
+// writeOutcome counts what happened to the schools of one transaction
+type writeOutcome struct {
+	inserted, updated, untouched int
+
+	// dryRunHashes are the hashes of the schools a dry run classified, by
+	// key
+	dryRunHashes map[string]uint64
+}
+
+// apply writes schools, or only classifies them in a dry run
+func (imp *schoolImporter) apply(schools []pendingSchool) (writeOutcome, error) {
+	if imp.opts.DryRun {
+		return imp.classifySchools(schools)
+	}
+	return imp.writeSchools(schools)
+}
+
+// record adds a committed outcome to the stats. Untouched schools were
+// duplicates in insert-only mode and unchanged otherwise.
+func (imp *schoolImporter) record(outcome writeOutcome) {
+	for key, hash := range outcome.dryRunHashes {
+		imp.dryRunHashes[key] = hash
+	}
+	imp.stats.Inserted += outcome.inserted
+	imp.stats.Updated += outcome.updated
+	if imp.opts.Mode == ImportInsertOnly {
+		imp.stats.SkippedDuplicate += outcome.untouched
+	} else {
+		imp.stats.Unchanged += outcome.untouched
+	}
+}
+
+// writeSchools writes schools in a single transaction
+func (imp *schoolImporter) writeSchools(schools []pendingSchool) (writeOutcome, error) {
+	var outcome writeOutcome
+
+	// Begin transaction
+	tx, err := imp.repo.DB.Begin()
+	if err != nil {
+		return outcome, fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Prepare the write statement
+	stmt, err := tx.Prepare(imp.query)
+	if err != nil {
+		return outcome, fmt.Errorf("error preparing statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for i := range schools {
+		var inserted bool
+		err := stmt.QueryRow(importValues(&schools[i].school)...).Scan(&inserted)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			outcome.untouched++
+		case err != nil:
+			return writeOutcome{}, fmt.Errorf("error writing school %d: %w", schools[i].school.ObjectID, err)
+		case inserted:
+			outcome.inserted++
+		default:
+			outcome.updated++
+		}
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return writeOutcome{}, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return outcome, nil
+}
+
+// classifySchools counts what writeSchools would do with schools without
+// writing them. A school whose key was already classified in this import
+// is compared with that school instead of the stored one.
+func (imp *schoolImporter) classifySchools(schools []pendingSchool) (writeOutcome, error) {
+	outcome := writeOutcome{dryRunHashes: make(map[string]uint64, len(schools))}
+
+	stmt, err := imp.repo.DB.Prepare(imp.query)
+	if err != nil {
+		return outcome, fmt.Errorf("error preparing statement: %w", err)
+	}
+	defer stmt.Close()
+
+	keyColumn := slices.Index(importColumns, string(imp.opts.Key))
+	var compared []int
+	for _, column := range updateColumns(imp.opts) {
+		compared = append(compared, slices.Index(importColumns, column))
+	}
+
+	for i := range schools {
+		school := &schools[i].school
+		key, _ := imp.opts.Key.value(school)
+		values := importValues(school)
+		incoming := make([]interface{}, len(compared))
+		for j, column := range compared {
+			incoming[j] = values[column]
+		}
+
+		hash := dryRunHash(incoming)
+		previous, ok := outcome.dryRunHashes[key]
+		if !ok {
+			previous, ok = imp.dryRunHashes[key]
+		}
+		if ok {
+			if imp.opts.Mode == ImportInsertOnly || previous == hash {
+				outcome.untouched++
+			} else {
+				outcome.updated++
+			}
+			outcome.dryRunHashes[key] = hash
+			continue
+		}
+
+		args := []interface{}{values[keyColumn]}
+		if imp.opts.Mode != ImportInsertOnly {
+			args = append(args, incoming...)
+		}
+		var changed bool
+		err := stmt.QueryRow(args...).Scan(&changed)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			outcome.inserted++
+		case err != nil:
+			return writeOutcome{}, fmt.Errorf("error checking school %d: %w", school.ObjectID, err)
+		case changed:
+			outcome.updated++
+		default:
+			outcome.untouched++
+		}
+		outcome.dryRunHashes[key] = hash
+	}
+
+	return outcome, nil
+}
+
+// dryRunHash hashes the compared values of a school, so a dry run only
+// keeps a number per key to tell whether a repeated key changes the school
+func dryRunHash(values []interface{}) uint64 {
+	h := fnv.New64a()
+	for _, value := range values {
+		fmt.Fprintf(h, "%#v\x00", value)
+	}
+	return h.Sum64()
+}
+
+// isDataError reports whether err was caused by the values being written,
+// such as an out of range number or a constraint violation, rather than by
+// the connection or the database
+func isDataError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	class := pqErr.Code.Class()
+	return class == "22" || class == "23"
+}
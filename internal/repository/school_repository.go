@@ -8,6 +8,33 @@ import (
 	"github.com/pistolricks/api-clients/internal/models"
 )
 
+// schoolColumns is the column list scanned by scanSchool
+const schoolColumns = `id, objectid, name, address, city, state, zip, country, county, countyfips,
+		latitude, longitude, level, st_grade, end_grade, enrollment, ft_teacher,
+		type, status, population, ncesid, districtid, naics_code, naics_desc,
+		website, telephone, sourcedate, val_date, val_method, source, shelter_id,
+		created_at, updated_at, st_grade_ord, end_grade_ord`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSchool scans a row selected with schoolColumns into a School.
+// Any extra destinations are scanned from the columns that follow.
+func scanSchool(row rowScanner, school *models.School, extra ...interface{}) error {
+	dest := []interface{}{
+		&school.ID, &school.ObjectID, &school.Name, &school.Address, &school.City,
+		&school.State, &school.Zip, &school.Country, &school.County, &school.CountyFIPS,
+		&school.Latitude, &school.Longitude, &school.Level, &school.StartGrade, &school.EndGrade,
+		&school.Enrollment, &school.FTTeacher, &school.Type, &school.Status, &school.Population,
+		&school.NCESID, &school.DistrictID, &school.NAICSCode, &school.NAICSDesc, &school.Website,
+		&school.Telephone, &school.SourceDate, &school.ValDate, &school.ValMethod, &school.Source,
+		&school.ShelterID, &school.CreatedAt, &school.UpdatedAt, &school.StartGradeOrd, &school.EndGradeOrd,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
 // SchoolRepository handles database operations for schools
 type SchoolRepository struct {
 	DB *sql.DB
@@ -29,7 +56,7 @@ func (r *SchoolRepository) Create(school *models.School) error {
 	) VALUES (
 		$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16,
 		$17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30
-	) RETURNING id, created_at, updated_at;
+	) RETURNING id, created_at, updated_at, st_grade_ord, end_grade_ord;
 	`
 
 	// Execute the query
@@ -41,7 +68,7 @@ func (r *SchoolRepository) Create(school *models.School) error {
 		school.FTTeacher, school.Type, school.Status, school.Population, school.NCESID,
 		school.DistrictID, school.NAICSCode, school.NAICSDesc, school.Website, school.Telephone,
 		school.SourceDate, school.ValDate, school.ValMethod, school.Source, school.ShelterID,
-	).Scan(&school.ID, &school.CreatedAt, &school.UpdatedAt)
+	).Scan(&school.ID, &school.CreatedAt, &school.UpdatedAt, &school.StartGradeOrd, &school.EndGradeOrd)
 
 	if err != nil {
 		return fmt.Errorf("failed to create school: %w", err)
@@ -61,26 +88,24 @@ func (r *SchoolRepository) Create(school *models.School) error {
 
 // GetByID retrieves a school by its ID
 func (r *SchoolRepository) GetByID(id int64) (*models.School, error) {
+	return r.GetFieldsByID(id, nil)
+}
+
+// GetFieldsByID retrieves a school by its ID, selecting only the sparse
+// fieldset fields, or every field when fields is nil
+func (r *SchoolRepository) GetFieldsByID(id int64, fields []string) (*models.School, error) {
+	if err := checkFields(fields); err != nil {
+		return nil, err
+	}
+
 	query := `
-	SELECT id, objectid, name, address, city, state, zip, country, county, countyfips,
-		latitude, longitude, level, st_grade, end_grade, enrollment, ft_teacher,
-		type, status, population, ncesid, districtid, naics_code, naics_desc,
-		website, telephone, sourcedate, val_date, val_method, source, shelter_id,
-		created_at, updated_at
+	SELECT ` + selectColumns(fields) + `
 	FROM schools
 	WHERE id = $1
 	`
 
 	var school models.School
-	err := r.DB.QueryRow(query, id).Scan(
-		&school.ID, &school.ObjectID, &school.Name, &school.Address, &school.City,
-		&school.State, &school.Zip, &school.Country, &school.County, &school.CountyFIPS,
-		&school.Latitude, &school.Longitude, &school.Level, &school.StartGrade, &school.EndGrade,
-		&school.Enrollment, &school.FTTeacher, &school.Type, &school.Status, &school.Population,
-		&school.NCESID, &school.DistrictID, &school.NAICSCode, &school.NAICSDesc, &school.Website,
-		&school.Telephone, &school.SourceDate, &school.ValDate, &school.ValMethod, &school.Source,
-		&school.ShelterID, &school.CreatedAt, &school.UpdatedAt,
-	)
+	err := scanSchoolFields(r.DB.QueryRow(query, id), &school, fields)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -95,25 +120,13 @@ func (r *SchoolRepository) GetByID(id int64) (*models.School, error) {
 // GetByObjectID retrieves a school by its ObjectID
 func (r *SchoolRepository) GetByObjectID(objectID int) (*models.School, error) {
 	query := `
-	SELECT id, objectid, name, address, city, state, zip, country, county, countyfips,
-		latitude, longitude, level, st_grade, end_grade, enrollment, ft_teacher,
-		type, status, population, ncesid, districtid, naics_code, naics_desc,
-		website, telephone, sourcedate, val_date, val_method, source, shelter_id,
-		created_at, updated_at
+	SELECT ` + schoolColumns + `
 	FROM schools
 	WHERE objectid = $1
 	`
 
 	var school models.School
-	err := r.DB.QueryRow(query, objectID).Scan(
-		&school.ID, &school.ObjectID, &school.Name, &school.Address, &school.City,
-		&school.State, &school.Zip, &school.Country, &school.County, &school.CountyFIPS,
-		&school.Latitude, &school.Longitude, &school.Level, &school.StartGrade, &school.EndGrade,
-		&school.Enrollment, &school.FTTeacher, &school.Type, &school.Status, &school.Population,
-		&school.NCESID, &school.DistrictID, &school.NAICSCode, &school.NAICSDesc, &school.Website,
-		&school.Telephone, &school.SourceDate, &school.ValDate, &school.ValMethod, &school.Source,
-		&school.ShelterID, &school.CreatedAt, &school.UpdatedAt,
-	)
+	err := scanSchool(r.DB.QueryRow(query, objectID), &school)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -125,57 +138,97 @@ func (r *SchoolRepository) GetByObjectID(objectID int) (*models.School, error) {
 	return &school, nil
 }
 
-// List retrieves all schools with pagination
-func (r *SchoolRepository) List(page, pageSize int) ([]*models.School, error) {
-	if page < 1 {
-		page = 1
+// ListQuery selects a page of schools for List
+type ListQuery struct {
+	Filter SchoolFilter
+	Sort   SchoolSort
+
+	// Fields is a sparse fieldset of models.SchoolFields; nil selects every
+	// field. The id is always selected.
+	Fields []string
+
+	// Cursor continues after a previous page; Page is used when it is empty
+	Cursor   string
+	Page     int
+	PageSize int
+}
+
+// List retrieves a page of the schools matching the query in sort order.
+// nextCursor continues after the last school returned; it is empty when no
+// schools follow.
+func (r *SchoolRepository) List(q ListQuery) (schools []*models.School, nextCursor string, err error) {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PageSize < 1 {
+		q.PageSize = 10
 	}
-	if pageSize < 1 {
-		pageSize = 10
+	if err := checkFields(q.Fields); err != nil {
+		return nil, "", err
 	}
+	fields := withID(q.Fields)
 
-	offset := (page - 1) * pageSize
+	var args queryArgs
+	where := q.Filter.where(&args)
 
+	offset := 0
+	if q.Cursor != "" {
+		position, err := q.Sort.decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		where += " AND " + q.Sort.after(position, &args)
+	} else {
+		offset = (q.Page - 1) * q.PageSize
+	}
+
+	selectList := selectColumns(fields)
+	if len(q.Sort.Keys) > 0 {
+		selectList += ", " + q.Sort.sortValues(&args)
+	}
+
+	// Fetch one extra row so we can tell whether another page follows
 	query := `
-	SELECT id, objectid, name, address, city, state, zip, country, county, countyfips,
-		latitude, longitude, level, st_grade, end_grade, enrollment, ft_teacher,
-		type, status, population, ncesid, districtid, naics_code, naics_desc,
-		website, telephone, sourcedate, val_date, val_method, source, shelter_id,
-		created_at, updated_at
+	SELECT ` + selectList + `
 	FROM schools
-	ORDER BY id
-	LIMIT $1 OFFSET $2
-	`
+	WHERE ` + where + `
+	ORDER BY ` + q.Sort.orderBy(&args) + `
+	LIMIT ` + args.add(q.PageSize+1) + ` OFFSET ` + args.add(offset)
 
-	rows, err := r.DB.Query(query, pageSize, offset)
+	rows, err := r.DB.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list schools: %w", err)
+		return nil, "", fmt.Errorf("failed to list schools: %w", err)
 	}
 	defer rows.Close()
 
-	var schools []*models.School
+	var lastValues []interface{}
 	for rows.Next() {
 		var school models.School
-		err := rows.Scan(
-			&school.ID, &school.ObjectID, &school.Name, &school.Address, &school.City,
-			&school.State, &school.Zip, &school.Country, &school.County, &school.CountyFIPS,
-			&school.Latitude, &school.Longitude, &school.Level, &school.StartGrade, &school.EndGrade,
-			&school.Enrollment, &school.FTTeacher, &school.Type, &school.Status, &school.Population,
-			&school.NCESID, &school.DistrictID, &school.NAICSCode, &school.NAICSDesc, &school.Website,
-			&school.Telephone, &school.SourceDate, &school.ValDate, &school.ValMethod, &school.Source,
-			&school.ShelterID, &school.CreatedAt, &school.UpdatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan school: %w", err)
+		values := make([]interface{}, len(q.Sort.Keys))
+		dest := make([]interface{}, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := scanSchoolFields(rows, &school, fields, dest...); err != nil {
+			return nil, "", fmt.Errorf("failed to scan school: %w", err)
+		}
+		if len(schools) < q.PageSize {
+			schools = append(schools, &school)
+			lastValues = values
+		} else {
+			// The extra row only shows that another page follows
+			last := schools[len(schools)-1]
+			if nextCursor, err = q.Sort.encodeCursor(lastValues, last.ID); err != nil {
+				return nil, "", err
+			}
 		}
-		schools = append(schools, &school)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating schools: %w", err)
+		return nil, "", fmt.Errorf("error iterating schools: %w", err)
 	}
 
-	return schools, nil
+	return schools, nextCursor, nil
 }
 
 // Update updates a school in the database
@@ -190,7 +243,7 @@ func (r *SchoolRepository) Update(school *models.School) error {
 		sourcedate = $26, val_date = $27, val_method = $28, source = $29, shelter_id = $30,
 		updated_at = $31
 	WHERE id = $32
-	RETURNING updated_at
+	RETURNING updated_at, st_grade_ord, end_grade_ord
 	`
 
 	// Execute the query
@@ -203,7 +256,7 @@ func (r *SchoolRepository) Update(school *models.School) error {
 		school.DistrictID, school.NAICSCode, school.NAICSDesc, school.Website, school.Telephone,
 		school.SourceDate, school.ValDate, school.ValMethod, school.Source, school.ShelterID,
 		time.Now(), school.ID,
-	).Scan(&school.UpdatedAt)
+	).Scan(&school.UpdatedAt, &school.StartGradeOrd, &school.EndGradeOrd)
 
 	if err != nil {
 		return fmt.Errorf("failed to update school: %w", err)
@@ -230,10 +283,13 @@ func (r *SchoolRepository) Delete(id int64) error {
 	return nil
 }
 
-// Count returns the total number of schools
-func (r *SchoolRepository) Count() (int, error) {
+// Count returns the number of schools matching filter
+func (r *SchoolRepository) Count(filter SchoolFilter) (int, error) {
+	var args queryArgs
+	where := filter.where(&args)
+
 	var count int
-	err := r.DB.QueryRow("SELECT COUNT(*) FROM schools").Scan(&count)
+	err := r.DB.QueryRow("SELECT COUNT(*) FROM schools WHERE "+where, args...).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count schools: %w", err)
 	}
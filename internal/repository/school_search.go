@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+// SearchResult is a school matched by a search and how well it matched
+type SearchResult struct {
+	School *models.School
+	Score  float64
+}
+
+// prefixTSQuery turns free text into a to_tsquery expression that requires
+// every word, each matched as a prefix so "elem" finds "elementary". It
+// returns the empty string when the text has no words.
+func prefixTSQuery(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// Search finds schools by name, city and address, best matches first.
+// Full-text matching finds schools containing every word of the query;
+// trigram word similarity also finds them when words are misspelled.
+func (r *SchoolRepository) Search(text string, limit int) ([]SearchResult, error) {
+	if limit < 1 {
+		limit = 20
+	}
+
+	tsQuery := prefixTSQuery(text)
+	if tsQuery == "" {
+		return nil, nil
+	}
+
+	query := `
+	SELECT ` + schoolColumns + `,
+		ts_rank_cd(search_vector, to_tsquery('simple', $1)) + word_similarity($2, search_text) AS score
+	FROM schools
+	WHERE search_vector @@ to_tsquery('simple', $1) OR $2 <% search_text
+	ORDER BY score DESC, id
+	LIMIT $3
+	`
+
+	rows, err := r.DB.Query(query, tsQuery, strings.ToLower(text), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search schools: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var school models.School
+		var score float64
+		if err := scanSchool(rows, &school, &score); err != nil {
+			return nil, fmt.Errorf("failed to scan school: %w", err)
+		}
+		results = append(results, SearchResult{School: &school, Score: score})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating schools: %w", err)
+	}
+
+	return results, nil
+}
+
+// likeEscaper escapes the LIKE wildcards in user input
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// autocompleteWordPrefixMin is the shortest prefix also matched against the
+// later words of names. Shorter prefixes have no selective trigrams, so
+// they only match the start of names.
+const autocompleteWordPrefixMin = 3
+
+// Autocomplete suggests schools whose name, or a word in it, starts with
+// prefix. Prefixes shorter than autocompleteWordPrefixMin characters only
+// match the start of names. Names starting with the prefix come first. When
+// center is non-nil each group is ordered by distance from it, otherwise by
+// name.
+func (r *SchoolRepository) Autocomplete(prefix string, limit int, center *Point) ([]models.SchoolSuggestion, error) {
+	if limit < 1 {
+		limit = 10
+	}
+
+	// Name prefixes are read from schools_name_prefix_idx first, and later
+	// words are only searched when they leave room. Each query stops after
+	// the schools it needs, so common prefixes stay fast.
+	escaped := likeEscaper.Replace(strings.ToLower(prefix))
+	var args queryArgs
+	where := "lower(name) LIKE " + args.add(escaped+"%")
+	suggestions, err := r.autocomplete(where, args, limit, center)
+	if err != nil || len(suggestions) == limit || utf8.RuneCountInString(prefix) < autocompleteWordPrefixMin {
+		return suggestions, err
+	}
+
+	// Later words use schools_name_trgm_idx, skipping the names found above
+	args = nil
+	where = "lower(name) LIKE " + args.add("% "+escaped+"%") + " AND lower(name) NOT LIKE " + args.add(escaped+"%")
+	words, err := r.autocomplete(where, args, limit-len(suggestions), center)
+	return append(suggestions, words...), err
+}
+
+// autocomplete returns up to limit suggestions matching where, ordered by
+// distance from center when it is non-nil and otherwise by name in the
+// order of schools_name_prefix_idx
+func (r *SchoolRepository) autocomplete(where string, args queryArgs, limit int, center *Point) ([]models.SchoolSuggestion, error) {
+	order := "lower(name) USING ~<~"
+	if center != nil {
+		order = fmt.Sprintf("location <-> ST_SetSRID(ST_MakePoint(%s, %s), 4326)", args.add(center.Lon), args.add(center.Lat))
+	}
+
+	query := `
+	SELECT id, name, city, state, latitude, longitude
+	FROM schools
+	WHERE ` + where + `
+	ORDER BY ` + order + `, id
+	LIMIT ` + args.add(limit) + `
+	`
+
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to autocomplete schools: %w", err)
+	}
+	defer rows.Close()
+
+	var suggestions []models.SchoolSuggestion
+	for rows.Next() {
+		var suggestion models.SchoolSuggestion
+		var city, state sql.NullString
+		err := rows.Scan(
+			&suggestion.ID, &suggestion.Name, &city, &state,
+			&suggestion.Latitude, &suggestion.Longitude,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan suggestion: %w", err)
+		}
+		suggestion.City = city.String
+		suggestion.State = state.String
+		suggestions = append(suggestions, suggestion)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating suggestions: %w", err)
+	}
+
+	return suggestions, nil
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidSort is returned by ParseSchoolSort for unknown or repeated keys
+var ErrInvalidSort = errors.New("invalid sort")
+
+// sortColumns maps the sort keys accepted by ParseSchoolSort to columns.
+// The distance key is computed from the sort origin instead.
+var sortColumns = map[string]string{
+	"name":       "name",
+	"enrollment": "enrollment",
+	"ft_teacher": "ft_teacher",
+	"state":      "state",
+	"city":       "city",
+	"updated_at": "updated_at",
+}
+
+// sortDistance is the sort key for the distance from the sort origin
+const sortDistance = "distance"
+
+// SortKey is one key of a SchoolSort
+type SortKey struct {
+	Field string
+	Desc  bool
+
+	// column replaces the expression of Field, for sorts built internally
+	// on expressions ParseSchoolSort does not accept
+	column string
+}
+
+// SchoolSort orders listed schools. Schools that tie on every key, or have
+// no keys at all, are ordered by id so pages are stable.
+type SchoolSort struct {
+	Keys []SortKey
+
+	// Origin is the reference point for the distance key
+	Origin *Point
+}
+
+// ParseSchoolSort parses a comma separated list of sort keys, each prefixed
+// with - for descending order, such as "-enrollment,name". The distance key
+// requires an origin.
+func ParseSchoolSort(value string, origin *Point) (SchoolSort, error) {
+	sort := SchoolSort{Origin: origin}
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key := SortKey{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		if _, ok := sortColumns[key.Field]; !ok && key.Field != sortDistance {
+			return sort, fmt.Errorf("%w: unknown key %q", ErrInvalidSort, key.Field)
+		}
+		if key.Field == sortDistance && origin == nil {
+			return sort, fmt.Errorf("%w: distance requires a reference point", ErrInvalidSort)
+		}
+		if seen[key.Field] {
+			return sort, fmt.Errorf("%w: repeated key %q", ErrInvalidSort, key.Field)
+		}
+		seen[key.Field] = true
+
+		sort.Keys = append(sort.Keys, key)
+	}
+
+	return sort, nil
+}
+
+// expression returns the SQL expression a key sorts on
+func (s SchoolSort) expression(key SortKey, args *queryArgs) string {
+	if key.Field == sortDistance {
+		return fmt.Sprintf(
+			"ST_Distance(location::geography, ST_SetSRID(ST_MakePoint(%s, %s), 4326)::geography)",
+			args.add(s.Origin.Lon), args.add(s.Origin.Lat),
+		)
+	}
+	if key.column != "" {
+		return key.column
+	}
+	return sortColumns[key.Field]
+}
+
+// orderBy returns the ORDER BY list for the sort. Missing values sort last
+// in either direction.
+func (s SchoolSort) orderBy(args *queryArgs) string {
+	var terms []string
+	for _, key := range s.Keys {
+		direction := "ASC"
+		if key.Desc {
+			direction = "DESC"
+		}
+		terms = append(terms, fmt.Sprintf("%s %s NULLS LAST", s.expression(key, args), direction))
+	}
+	return strings.Join(append(terms, "id"), ", ")
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+// BoundingBox is a WGS84 envelope in longitude/latitude order
+type BoundingBox struct {
+	MinLon float64
+	MinLat float64
+	MaxLon float64
+	MaxLat float64
+}
+
+// emptyExtent returns a box holding nothing, for extend to grow
+func emptyExtent() BoundingBox {
+	return BoundingBox{MinLon: math.Inf(1), MinLat: math.Inf(1), MaxLon: math.Inf(-1), MaxLat: math.Inf(-1)}
+}
+
+// extend grows the box to include lon, lat
+func (b *BoundingBox) extend(lon, lat float64) {
+	b.MinLon = math.Min(b.MinLon, lon)
+	b.MinLat = math.Min(b.MinLat, lat)
+	b.MaxLon = math.Max(b.MaxLon, lon)
+	b.MaxLat = math.Max(b.MaxLat, lat)
+}
+
+// Point is a WGS84 location
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+// ListInBBox retrieves the schools matching the query whose location falls
+// inside the bounding box. Paging is ignored: at most q.PageSize schools are
+// returned and truncated reports whether more matched.
+func (r *SchoolRepository) ListInBBox(bbox BoundingBox, q ListQuery) ([]*models.School, bool, error) {
+	q.Filter.BBox = &bbox
+	q.Cursor = ""
+	q.Page = 1
+
+	schools, nextCursor, err := r.List(q)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list schools in bbox: %w", err)
+	}
+	return schools, nextCursor != "", nil
+}
+
+// NearbySchool is a school together with its distance from a reference point
+type NearbySchool struct {
+	School         *models.School
+	DistanceMeters float64
+}
+
+// Nearby retrieves the schools closest to the given point, nearest first.
+// When radiusMeters is positive, schools farther away than that are excluded.
+func (r *SchoolRepository) Nearby(lat, lon, radiusMeters float64, limit int) ([]NearbySchool, error) {
+	if limit < 1 {
+		limit = 10
+	}
+
+	args := []interface{}{lon, lat, limit}
+	radiusClause := ""
+	if radiusMeters > 0 {
+		radiusClause = "AND ST_DWithin(location::geography, ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography, $4, false)"
+		args = append(args, radiusMeters)
+	}
+
+	// Distances are measured on the sphere, which is what the geography KNN
+	// operator orders by, so schools_location_geog_idx returns the nearest
+	// schools in the order of their reported distances
+	query := `
+	SELECT ` + schoolColumns + `,
+		ST_Distance(location::geography, ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography, false) AS distance_m
+	FROM schools
+	WHERE location IS NOT NULL ` + radiusClause + `
+	ORDER BY location::geography <-> ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography, id
+	LIMIT $3
+	`
+
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find nearby schools: %w", err)
+	}
+	defer rows.Close()
+
+	var results []NearbySchool
+	for rows.Next() {
+		var school models.School
+		var distance float64
+		if err := scanSchool(rows, &school, &distance); err != nil {
+			return nil, fmt.Errorf("failed to scan school: %w", err)
+		}
+		results = append(results, NearbySchool{School: &school, DistanceMeters: distance})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating schools: %w", err)
+	}
+
+	return results, nil
+}
+
+// Clusters groups the schools matching filter inside the bounding box into
+// square grid cells of cellSize degrees. Each cluster is positioned at the mean location of its
+// schools and carries a count per level; schools without a level are counted
+// as UNKNOWN.
+func (r *SchoolRepository) Clusters(bbox BoundingBox, filter SchoolFilter, cellSize float64) ([]models.SchoolCluster, error) {
+	if cellSize <= 0 {
+		return nil, fmt.Errorf("cell size must be positive")
+	}
+
+	filter.BBox = &bbox
+	var args queryArgs
+	cell := args.add(cellSize)
+	where := filter.where(&args)
+
+	query := `
+	WITH by_level AS (
+		SELECT floor(ST_X(location) / ` + cell + `) AS cell_x,
+			floor(ST_Y(location) / ` + cell + `) AS cell_y,
+			COALESCE(NULLIF(level, ''), 'UNKNOWN') AS level,
+			COUNT(*) AS n,
+			SUM(ST_X(location)) AS sum_x,
+			SUM(ST_Y(location)) AS sum_y
+		FROM schools
+		WHERE ` + where + `
+		GROUP BY 1, 2, 3
+	)
+	SELECT SUM(sum_y) / SUM(n), SUM(sum_x) / SUM(n), SUM(n), json_object_agg(level, n)
+	FROM by_level
+	GROUP BY cell_x, cell_y
+	ORDER BY cell_x, cell_y
+	`
+
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cluster schools: %w", err)
+	}
+	defer rows.Close()
+
+	var clusters []models.SchoolCluster
+	for rows.Next() {
+		var cluster models.SchoolCluster
+		var levels []byte
+		if err := rows.Scan(&cluster.Latitude, &cluster.Longitude, &cluster.Count, &levels); err != nil {
+			return nil, fmt.Errorf("failed to scan cluster: %w", err)
+		}
+		if err := json.Unmarshal(levels, &cluster.Levels); err != nil {
+			return nil, fmt.Errorf("failed to decode cluster levels: %w", err)
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating clusters: %w", err)
+	}
+
+	return clusters, nil
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SchoolTileLayer is the layer name used in generated vector tiles
+const SchoolTileLayer = "schools"
+
+// tileAttributeColumns maps the attributes that may be included in vector
+// tiles to their schools table columns
+var tileAttributeColumns = map[string]string{
+	"id":         "id",
+	"objectid":   "objectid",
+	"name":       "name",
+	"city":       "city",
+	"state":      "state",
+	"level":      "level",
+	"st_grade":   "st_grade",
+	"end_grade":  "end_grade",
+	"enrollment": "enrollment",
+	"ft_teacher": "ft_teacher",
+	"type":       "type",
+	"status":     "status",
+}
+
+// DefaultTileAttributes are included in vector tiles when none are requested
+var DefaultTileAttributes = []string{"name", "level", "enrollment"}
+
+// ValidateTileAttributes checks that every attribute may be included in a
+// tile and is only requested once
+func ValidateTileAttributes(attributes []string) error {
+	seen := make(map[string]bool)
+	for _, attr := range attributes {
+		if _, ok := tileAttributeColumns[attr]; !ok {
+			return fmt.Errorf("unknown tile attribute %q", attr)
+		}
+		if seen[attr] {
+			return fmt.Errorf("repeated tile attribute %q", attr)
+		}
+		seen[attr] = true
+	}
+	return nil
+}
+
+// SchoolTile renders the schools in tile z/x/y as a Mapbox Vector Tile.
+// Every feature carries its id plus the requested attributes.
+func (r *SchoolRepository) SchoolTile(z, x, y int, attributes []string) ([]byte, error) {
+	if len(attributes) == 0 {
+		attributes = DefaultTileAttributes
+	}
+	if err := ValidateTileAttributes(attributes); err != nil {
+		return nil, err
+	}
+
+	columns := tileColumns(attributes)
+
+	query := `
+	WITH bounds AS (
+		SELECT ST_TileEnvelope($1, $2, $3) AS geom
+	),
+	mvtgeom AS (
+		SELECT ST_AsMVTGeom(ST_Transform(s.location, 3857), bounds.geom) AS geom,
+			` + strings.Join(columns, ", ") + `
+		FROM schools s, bounds
+		WHERE s.location && ST_Transform(bounds.geom, 4326)
+	)
+	SELECT ST_AsMVT(mvtgeom.*, $4, 4096, 'geom') FROM mvtgeom
+	`
+
+	var tile []byte
+	if err := r.DB.QueryRow(query, z, x, y, SchoolTileLayer).Scan(&tile); err != nil {
+		return nil, fmt.Errorf("failed to render school tile: %w", err)
+	}
+
+	return tile, nil
+}
+
+// tileColumns returns the columns of schools s carried by tile features:
+// the id plus the attributes
+func tileColumns(attributes []string) []string {
+	columns := []string{"s.id"}
+	for _, attr := range attributes {
+		if attr == "id" {
+			continue
+		}
+		columns = append(columns, "s."+tileAttributeColumns[attr])
+	}
+	return columns
+}
@@ -0,0 +1,406 @@
+package repository
+
+import (
+	"archive/zip"
+	"bufio"
+	"database/sql"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pistolricks/api-clients/internal/models"
+)
+
+// ExportShapefile is the export format written by NewShapefileWriter
+const ExportShapefile = "shp"
+
+// shapefileName is the base name of the files inside the zip archive
+const shapefileName = "schools"
+
+// shapefilePRJ is the ESRI WKT of WGS 84
+const shapefilePRJ = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+// dbfField is a DBF column and the School field it is written from
+type dbfField struct {
+	name     string
+	field    string
+	kind     byte
+	width    int
+	decimals int
+}
+
+// shapefileFields maps every models.SchoolFields entry to a DBF column.
+// DBF column names are limited to 10 characters, so longer field names are
+// abbreviated; the mapping is written to the archive as schools_fields.csv.
+var shapefileFields = []dbfField{
+	{"id", "id", 'N', 10, 0},
+	{"objectid", "objectid", 'N', 18, 0},
+	{"name", "name", 'C', 254, 0},
+	{"address", "address", 'C', 254, 0},
+	{"city", "city", 'C', 80, 0},
+	{"state", "state", 'C', 20, 0},
+	{"zip", "zip", 'C', 10, 0},
+	{"country", "country", 'C', 20, 0},
+	{"county", "county", 'C', 80, 0},
+	{"countyfips", "countyfips", 'C', 5, 0},
+	{"latitude", "latitude", 'N', 19, 11},
+	{"longitude", "longitude", 'N', 19, 11},
+	{"level", "level", 'C', 20, 0},
+	{"st_grade", "st_grade", 'C', 4, 0},
+	{"end_grade", "end_grade", 'C', 4, 0},
+	{"enrollment", "enrollment", 'N', 10, 0},
+	{"ft_teacher", "ft_teacher", 'N', 10, 0},
+	{"type", "type", 'N', 4, 0},
+	{"status", "status", 'N', 4, 0},
+	{"population", "population", 'N', 10, 0},
+	{"ncesid", "ncesid", 'C', 20, 0},
+	{"districtid", "districtid", 'C', 20, 0},
+	{"naics_code", "naics_code", 'C', 10, 0},
+	{"naics_desc", "naics_desc", 'C', 254, 0},
+	{"website", "website", 'C', 254, 0},
+	{"telephone", "telephone", 'C', 20, 0},
+	{"sourcedate", "sourcedate", 'D', 8, 0},
+	{"val_date", "val_date", 'D', 8, 0},
+	{"val_method", "val_method", 'C', 80, 0},
+	{"source", "source", 'C', 254, 0},
+	{"shelter_id", "shelter_id", 'C', 20, 0},
+	{"created_at", "created_at", 'C', 20, 0},
+	{"updated_at", "updated_at", 'C', 20, 0},
+	{"st_grd_ord", "st_grade_ord", 'N', 2, 0},
+	{"end_grd_or", "end_grade_ord", 'N', 2, 0},
+}
+
+// Shapefile layout constants: headers are 100 bytes and a point record is
+// an 8 byte record header followed by 20 bytes of content
+const (
+	shapefileHeaderSize  = 100
+	shapefilePointLength = 20
+	shapefileRecordSize  = 8 + shapefilePointLength
+	shapefilePointType   = 1
+)
+
+// shapefileWriter writes schools as a point shapefile zipped with its
+// projection, code page and field mapping
+type shapefileWriter struct {
+	path string
+	dir  string
+
+	shpFile, shxFile, dbfFile *os.File
+	shp, shx, dbf             *bufio.Writer
+
+	count  int
+	extent BoundingBox
+	record []byte
+}
+
+// NewShapefileWriter creates a zip archive at path holding schools.shp,
+// .shx, .dbf, .prj and .cpg, and schools_fields.csv documenting which DBF
+// column each school field is written to. The files are built in a
+// temporary directory and zipped by Close.
+func NewShapefileWriter(path string) (SchoolWriter, error) {
+	if err := ensureNewFile("shapefile", path); err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "schools-shapefile-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shapefile: %w", err)
+	}
+
+	sw := &shapefileWriter{
+		path:   path,
+		dir:    dir,
+		extent: emptyExtent(),
+		record: make([]byte, dbfRecordLength()),
+	}
+	if err := sw.create(); err != nil {
+		sw.cleanup()
+		return nil, fmt.Errorf("failed to create shapefile: %w", err)
+	}
+	return sw, nil
+}
+
+// create opens the component files and reserves space for their headers,
+// which are written once the record count and extent are known
+func (sw *shapefileWriter) create() error {
+	open := func(ext string, headerSize int) (*os.File, *bufio.Writer, error) {
+		file, err := os.Create(filepath.Join(sw.dir, shapefileName+ext))
+		if err != nil {
+			return nil, nil, err
+		}
+		w := bufio.NewWriter(file)
+		_, err = w.Write(make([]byte, headerSize))
+		return file, w, err
+	}
+
+	var err error
+	if sw.shpFile, sw.shp, err = open(".shp", shapefileHeaderSize); err != nil {
+		return err
+	}
+	if sw.shxFile, sw.shx, err = open(".shx", shapefileHeaderSize); err != nil {
+		return err
+	}
+	sw.dbfFile, sw.dbf, err = open(".dbf", dbfHeaderLength())
+	return err
+}
+
+func (sw *shapefileWriter) WriteSchool(school *models.School) error {
+	offset := shapefileHeaderSize + sw.count*shapefileRecordSize
+	sw.count++
+
+	// Record header and offsets are big endian, shape content little endian
+	var buf [shapefileRecordSize]byte
+	binary.BigEndian.PutUint32(buf[0:], uint32(sw.count))
+	binary.BigEndian.PutUint32(buf[4:], shapefilePointLength/2)
+	binary.LittleEndian.PutUint32(buf[8:], shapefilePointType)
+	binary.LittleEndian.PutUint64(buf[12:], math.Float64bits(school.Longitude))
+	binary.LittleEndian.PutUint64(buf[20:], math.Float64bits(school.Latitude))
+	if _, err := sw.shp.Write(buf[:]); err != nil {
+		return err
+	}
+
+	var index [8]byte
+	binary.BigEndian.PutUint32(index[0:], uint32(offset/2))
+	binary.BigEndian.PutUint32(index[4:], shapefilePointLength/2)
+	if _, err := sw.shx.Write(index[:]); err != nil {
+		return err
+	}
+
+	sw.record[0] = ' ' // not deleted
+	pos := 1
+	for _, f := range shapefileFields {
+		copy(sw.record[pos:pos+f.width], dbfValue(f, schoolFieldDest(school, f.field)))
+		pos += f.width
+	}
+	if _, err := sw.dbf.Write(sw.record); err != nil {
+		return err
+	}
+
+	sw.extent.extend(school.Longitude, school.Latitude)
+	return nil
+}
+
+// Close writes the file headers and zips the shapefile to the output path
+func (sw *shapefileWriter) Close() error {
+	defer sw.cleanup()
+
+	if err := sw.finish(); err != nil {
+		return fmt.Errorf("failed to finish shapefile: %w", err)
+	}
+	if err := sw.zip(); err != nil {
+		os.Remove(sw.path)
+		return fmt.Errorf("failed to zip shapefile: %w", err)
+	}
+	return nil
+}
+
+// finish flushes the component files, fills in their headers and writes
+// the sidecar files
+func (sw *shapefileWriter) finish() error {
+	if err := sw.dbf.WriteByte(0x1A); err != nil {
+		return err
+	}
+	for _, w := range []*bufio.Writer{sw.shp, sw.shx, sw.dbf} {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	extent := sw.extent
+	if sw.count == 0 {
+		extent = BoundingBox{}
+	}
+	shpLength := shapefileHeaderSize + sw.count*shapefileRecordSize
+	shxLength := shapefileHeaderSize + sw.count*8
+	if _, err := sw.shpFile.WriteAt(shapefileHeader(shpLength, extent), 0); err != nil {
+		return err
+	}
+	if _, err := sw.shxFile.WriteAt(shapefileHeader(shxLength, extent), 0); err != nil {
+		return err
+	}
+	if _, err := sw.dbfFile.WriteAt(dbfHeader(sw.count, time.Now()), 0); err != nil {
+		return err
+	}
+
+	sidecars := map[string]string{
+		shapefileName + ".prj": shapefilePRJ,
+		shapefileName + ".cpg": "UTF-8",
+	}
+	for name, content := range sidecars {
+		if err := os.WriteFile(filepath.Join(sw.dir, name), []byte(content), 0o644); err != nil {
+			return err
+		}
+	}
+	return writeShapefileFieldMapping(filepath.Join(sw.dir, shapefileName+"_fields.csv"))
+}
+
+// zip writes the component files to the output archive
+func (sw *shapefileWriter) zip() error {
+	out, err := os.Create(sw.path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	archive := zip.NewWriter(out)
+	for _, ext := range []string{".shp", ".shx", ".dbf", ".prj", ".cpg", "_fields.csv"} {
+		if err := addZipFile(archive, filepath.Join(sw.dir, shapefileName+ext)); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// addZipFile copies a file into the archive under its base name
+func addZipFile(archive *zip.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     filepath.Base(path),
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// abort removes the temporary component files without writing the archive
+func (sw *shapefileWriter) abort() {
+	sw.cleanup()
+}
+
+// cleanup closes and removes the temporary component files
+func (sw *shapefileWriter) cleanup() {
+	for _, file := range []*os.File{sw.shpFile, sw.shxFile, sw.dbfFile} {
+		if file != nil {
+			file.Close()
+		}
+	}
+	os.RemoveAll(sw.dir)
+}
+
+// shapefileHeader returns the 100 byte .shp or .shx header for a point
+// file of length bytes
+func shapefileHeader(length int, extent BoundingBox) []byte {
+	header := make([]byte, shapefileHeaderSize)
+	binary.BigEndian.PutUint32(header[0:], 9994)
+	binary.BigEndian.PutUint32(header[24:], uint32(length/2))
+	binary.LittleEndian.PutUint32(header[28:], 1000)
+	binary.LittleEndian.PutUint32(header[32:], shapefilePointType)
+	for i, v := range []float64{extent.MinLon, extent.MinLat, extent.MaxLon, extent.MaxLat} {
+		binary.LittleEndian.PutUint64(header[36+8*i:], math.Float64bits(v))
+	}
+	return header
+}
+
+// dbfHeaderLength is the size of the DBF header with its field descriptors
+func dbfHeaderLength() int {
+	return 32 + 32*len(shapefileFields) + 1
+}
+
+// dbfRecordLength is the size of a DBF record including its deletion flag
+func dbfRecordLength() int {
+	length := 1
+	for _, f := range shapefileFields {
+		length += f.width
+	}
+	return length
+}
+
+// dbfHeader returns the dBASE III header for count records
+func dbfHeader(count int, modified time.Time) []byte {
+	header := make([]byte, dbfHeaderLength())
+	header[0] = 0x03
+	header[1] = byte(modified.Year() - 1900)
+	header[2] = byte(modified.Month())
+	header[3] = byte(modified.Day())
+	binary.LittleEndian.PutUint32(header[4:], uint32(count))
+	binary.LittleEndian.PutUint16(header[8:], uint16(dbfHeaderLength()))
+	binary.LittleEndian.PutUint16(header[10:], uint16(dbfRecordLength()))
+
+	for i, f := range shapefileFields {
+		descriptor := header[32+32*i:]
+		copy(descriptor[0:11], f.name)
+		descriptor[11] = f.kind
+		descriptor[16] = byte(f.width)
+		descriptor[17] = byte(f.decimals)
+	}
+	header[len(header)-1] = 0x0D
+	return header
+}
+
+// dbfValue formats a School field for a DBF column: text is left aligned
+// and cut to the column width, numbers are right aligned, and nulls are
+// blank
+func dbfValue(f dbfField, field interface{}) []byte {
+	var value string
+	switch f.kind {
+	case 'D':
+		if v, ok := field.(*sql.NullTime); ok && v.Valid {
+			value = v.Time.Format("20060102")
+		}
+	case 'N':
+		if v, ok := field.(*float64); ok {
+			value = strconv.FormatFloat(*v, 'f', f.decimals, 64)
+		} else {
+			value = formatField(field)
+		}
+		if len(value) > f.width {
+			value = strings.Repeat("*", f.width)
+		}
+		value = strings.Repeat(" ", f.width-len(value)) + value
+	default:
+		value = truncateUTF8(formatField(field), f.width)
+	}
+	return []byte(value + strings.Repeat(" ", f.width-len(value)))
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// writeShapefileFieldMapping documents the DBF columns and the school
+// fields they hold
+func writeShapefileFieldMapping(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"column", "field", "type", "width", "decimals"})
+	for _, f := range shapefileFields {
+		w.Write([]string{f.name, f.field, string(f.kind), strconv.Itoa(f.width), strconv.Itoa(f.decimals)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}